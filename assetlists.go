@@ -3,7 +3,9 @@ package assets
 import (
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
 	"sync"
 )
 
@@ -21,6 +23,35 @@ func defaultMux(n string, _ os.FileInfo) string {
 	return n
 }
 
+// rewriteMux returns a PathMux which strips the prefix off paths lying within
+// it and places them under the virtual directory mount, before handing them to
+// mux if any. Directories and files get rewritten the same way, with the paths
+// left without a leading slash and the top directory becoming /.
+func rewriteMux(strip, mount string, mux PathMux) PathMux {
+	mount = strings.Trim(path.Clean("/"+filepath.ToSlash(mount)), "/")
+
+	return func(file string, in os.FileInfo) string {
+		rel := filepath.Clean(file)
+
+		if strip != "" {
+			if stripped, err := filepath.Rel(strip, file); err == nil && stripped != ".." && !strings.HasPrefix(stripped, ".."+string(filepath.Separator)) {
+				rel = stripped
+			}
+		}
+
+		virtual := strings.Trim(path.Join(mount, filepath.ToSlash(rel)), "/")
+		if virtual == "." || virtual == "" {
+			virtual = "/"
+		}
+
+		if mux != nil {
+			return mux(virtual, in)
+		}
+
+		return virtual
+	}
+}
+
 // BasicAssetTree represent a directory structure and its corresponding assets
 type BasicAssetTree struct {
 	Dir      string
@@ -291,6 +322,15 @@ func DirListings(path string, valid PathValidator, mux PathMux) (*DirListing, er
 	return &dls, nil
 }
 
+// DirListingsAt returns a new DirListing like DirListings, where strip is
+// removed from the front of every directory and file path within it and the
+// rest is placed under the virtual directory mount before reaching mux. With
+// strip set to path, ./public/css/app.css is listed as css/app.css, or as
+// static/css/app.css once mounted at /static.
+func DirListingsAt(path string, valid PathValidator, mux PathMux, strip, mount string) (*DirListing, error) {
+	return DirListings(path, valid, rewriteMux(strip, mount, mux))
+}
+
 // EachDir calls the internal listings EachDir function
 func (dls *DirListing) EachDir(fx func(b *BasicAssetTree, realPath string)) {
 	dls.Listings.Each(fx)
@@ -11,8 +11,20 @@ import (
 //AssetMap provides a map of paths that contain assets of the specific filepaths
 type AssetMap map[string]string
 
-// ReloadAssetMap reloads the files into the map skipping the already found ones
+// ReloadAssetMap reloads the files into the map skipping the already found ones.
+// Paths matching the gitignore style skip patterns, relative to dir, or the
+// ignore files within dir are left out.
 func ReloadAssetMap(tree AssetMap, dir string, ext []string, skip []string) error {
+	rules, err := LoadIgnoreRules(dir, skip...)
+	if err != nil {
+		return err
+	}
+
+	return reloadAssetMap(tree, dir, ext, rules)
+}
+
+// reloadAssetMap reloads the files into the map, leaving out what the rules ignore.
+func reloadAssetMap(tree AssetMap, dir string, ext []string, rules *IgnoreRules) error {
 	var stat os.FileInfo
 	var err error
 
@@ -46,26 +58,26 @@ func ReloadAssetMap(tree AssetMap, dir string, ext []string, skip []string) erro
 	} else {
 		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 
-			//if info is nil or is a directory when we skip
-			if info == nil || info.IsDir() {
+			//if info is nil we skip
+			if info == nil {
 				return nil
 			}
 
-			repath := filepath.ToSlash(path)
-
-			if tree.Has(repath) {
+			//directories are only walked into when the rules allow
+			if info.IsDir() {
+				if path != dir && rules.Ignored(path, true) {
+					return filepath.SkipDir
+				}
 				return nil
 			}
 
-			if strings.Contains(repath, ".git") {
-				return nil
-			}
+			repath := filepath.ToSlash(path)
 
-			if strings.Index(repath, ".git") != -1 {
+			if tree.Has(repath) {
 				return nil
 			}
 
-			if hasIn(skip, repath) {
+			if rules.Ignored(path, false) {
 				return nil
 			}
 
@@ -1,18 +1,35 @@
 package assets
 
 import (
-	"bufio"
+	"archive/zip"
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/influx6/assets/vfiles"
 )
 
-var vfile = filepath.Join(os.Getenv("GOPATH"), "src/github.com/influx6/assets/vfiles/vfiles.go")
+// DefaultRoot names the generated directory collector variable unless
+// BindFSConfig.Root says otherwise.
+const DefaultRoot = "RootDirectory"
 
 // DevelopmentMode represents development mode for bfs files
 const DevelopmentMode = 0
@@ -22,28 +39,54 @@ const ProductionMode = 1
 
 // BindFSConfig provides a configuration struct for BindFS
 type BindFSConfig struct {
-	InDir           string        //directory path use as source
-	OutDir          string        //directory path to save file in
-	Package         string        //package name for the file
-	File            string        //file name of the file
-	Gzipped         bool          // to enable gzipping of filecontents
-	NoDecompression bool          // active only when Gzipped is true,this disables decompression of data response or forces compression of output when in debug mode
-	Production      bool          // to enable production mode as default
-	ValidPath       PathValidator //use to filter allowed paths
-	Mux             PathMux       //use to mutate path look
+	InDir           string             //directory path use as source, optional when Mounts are given
+	Mounts          []Mount            // directories bundled under their own virtual prefixes, along with InDir when set
+	StripPrefix     string             // removed from the front of the paths within InDir, usually InDir itself, so ./public/css/app.css is served as /css/app.css
+	MountAt         string             // virtual directory the paths within InDir are served under, after StripPrefix is removed
+	OutDir          string             //directory path to save file in
+	Package         string             //package name for the file
+	File            string             //file name of the file
+	Gzipped         bool               // to enable gzipping of filecontents
+	Codecs          []vfiles.Codec     // codecs files are compressed with when Gzipped is set, the smallest output per file wins. Defaults to gzip
+	Compression     *CompressionPolicy // when set with Gzipped, decides which files are worth compressing, otherwise every file is
+	NoDecompression bool               // active only when Gzipped is true,this disables decompression of data response or forces compression of output when in debug mode
+	Transformers    []Transformer      // rewrite the contents of the files they match in order, before they are hashed, compressed and embedded
+	DevTransforms   bool               // runs the Transformers whenever files are read from disk too, which needs those not built into vfiles registered with the runtime
+	Fingerprint     bool               // in production mode, publishes files under names holding their checksum, resolvable by their own through the generated Manifest
+	Production      bool               // to enable production mode as default
+	ModTime         time.Time          // when set, pins the modification time of every file and directory instead of using their real ones, which reproducible output needs
+	SharedRuntime   bool               // imports github.com/influx6/assets/vfiles instead of copying its sources into the generated file
+	Root            string             // name of the generated directory collector variable, defaults to RootDirectory
+	Prefix          string             // prefixed to the names the generated file declares besides Root, so several bundles can share a package
+	BuildTag        string             // when set, records a development <File>_dev.go built without the tag and a production <File>_prod.go built with it
+	ShardByDir      bool               // registers each top-level directory from its own <File>_shard_N.go file
+	ShardSize       int64              // when set, starts a new <File>_shard_N.go file once the current one holds this many bytes
+	Archive         bool               // in production mode, writes the files into a <File>.assets zip read at runtime instead of go literals
+	Embed           bool               // in production mode, stages the files within <File>_assets and embeds them with go:embed instead of go literals
+	Workers         int                // number of files read and compressed at once in production mode, defaults to the number of CPUs
+	Manifest        bool               // writes the RecordReport of every Record as <File>.manifest.json next to the generated file
+	Lenient         bool               // reports the paths which can not be read as skipped instead of failing Record and Check with a RecordError
+	WatchDelay      time.Duration      // how long Watch waits for changes to settle before recording, defaults to DefaultWatchDelay
+	IgnorePatterns  []string           // gitignore style patterns applied within InDir and every mount, along with their .gitignore and .assetsignore files
+	NoIgnoreFiles   bool               // stops .gitignore and .assetsignore files from being honoured
+	ValidPath       PathValidator      //use to filter allowed paths
+	Mux             PathMux            //use to mutate path look
 	Ignore          *regexp.Regexp
 }
 
 // BindFS provides the struct for creating and updating a go file containing static assets from a directory
 type BindFS struct {
-	config       *BindFSConfig
-	listing      *DirListing
-	mode         int64
-	endpoint     string
-	endpointDir  string
-	inputDir     string
-	curDir       string
-	vfileContent string
+	config      *BindFSConfig
+	listings    []*DirListing
+	rules       []*IgnoreRules
+	mode        int64
+	endpointDir string
+	curDir      string
+	cacheLock   sync.Mutex
+	cache       *encodeCache
+	skips       *skipList
+	reportLock  sync.Mutex
+	report      *RecordReport
 }
 
 // NewBindFS returns a new BindFS instance or an error if it fails to located directory
@@ -51,31 +94,59 @@ func NewBindFS(config *BindFSConfig) (*BindFS, error) {
 	vali := config.ValidPath
 	mux := config.Mux
 
+	if config.Root != "" && !token.IsIdentifier(config.Root) {
+		return nil, fmt.Errorf("---> BindFS: Root %q is not a valid identifier", config.Root)
+	}
+
+	if config.Prefix != "" && !token.IsIdentifier(config.Prefix) {
+		return nil, fmt.Errorf("---> BindFS: Prefix %q is not a valid identifier", config.Prefix)
+	}
+
+	if config.Fingerprint && config.Archive {
+		return nil, fmt.Errorf("---> BindFS: Fingerprint is not supported with Archive")
+	}
+
+	if err := validTransformers(config.Transformers); err != nil {
+		return nil, err
+	}
+
+	if config.BuildTag != "" && !buildTag.MatchString(config.BuildTag) {
+		return nil, fmt.Errorf("---> BindFS: BuildTag %q is not a valid build tag", config.BuildTag)
+	}
+
 	pwd, _ := os.Getwd()
-	input := filepath.Join(pwd, config.InDir)
 	endpoint := filepath.Join(pwd, config.OutDir, config.File+".go")
 	endpointDir := filepath.Dir(endpoint)
 
-	(config).ValidPath = func(path string, in os.FileInfo) bool {
-		if strings.Contains(path, ".git") {
-			return false
-		}
+	//every path turned down is recorded for the report of the next render, except
+	//for the output which only exists after the first render
+	skips := new(skipList)
 
+	(config).ValidPath = func(path string, in os.FileInfo) bool {
 		if config.Ignore != nil && config.Ignore.MatchString(path) {
+			skips.add(filepath.ToSlash(path), SkipIgnored)
 			return false
 		}
 
-		if path == config.OutDir || strings.Contains(path, config.OutDir) {
+		// the directory of the generated file is never embedded
+		if within(endpointDir, path) {
 			return false
 		}
 
-		// check if this contains the endpoint directory. If so, ignore.
-		if strings.Contains(path, endpointDir) || strings.Contains(filepath.Join(pwd, path), endpointDir) {
+		if vali != nil && !vali(path, in) {
+			skips.add(filepath.ToSlash(path), SkipRejected)
 			return false
 		}
 
-		if vali != nil {
-			return vali(path, in)
+		// directories which can not be read would otherwise drop out of the listing unnoticed
+		if in != nil && in.IsDir() {
+			dir, err := os.Open(path)
+			if err != nil {
+				skips.fail(filepath.ToSlash(path), err)
+				return false
+			}
+
+			dir.Close()
 		}
 
 		return true
@@ -95,18 +166,50 @@ func NewBindFS(config *BindFSConfig) (*BindFS, error) {
 		return path
 	}
 
-	ls, err := DirListings(config.InDir, config.ValidPath, config.Mux)
+	var listings []*DirListing
+	var rules []*IgnoreRules
 
-	if err != nil {
-		return nil, fmt.Errorf("---> BindFS: Unable to create dirlisting for %s -> %s", config.InDir, err)
+	if config.InDir != "" || len(config.Mounts) == 0 {
+		ignores, err := ignoreRules(config.InDir, config.IgnorePatterns, config.NoIgnoreFiles)
+		if err != nil {
+			return nil, fmt.Errorf("---> BindFS: Unable to read ignore files within %s -> %s", config.InDir, err)
+		}
+
+		var ls *DirListing
+		if config.StripPrefix != "" || config.MountAt != "" {
+			ls, err = DirListingsAt(config.InDir, ignoring(ignores, config.ValidPath, skips), mux, config.StripPrefix, config.MountAt)
+		} else {
+			ls, err = DirListings(config.InDir, ignoring(ignores, config.ValidPath, skips), config.Mux)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("---> BindFS: Unable to create dirlisting for %s -> %s", config.InDir, err)
+		}
+
+		listings, rules = append(listings, ls), append(rules, ignores)
+	}
+
+	for _, mount := range config.Mounts {
+		ignores, err := ignoreRules(mount.Dir, config.IgnorePatterns, config.NoIgnoreFiles)
+		if err != nil {
+			return nil, fmt.Errorf("---> BindFS: Unable to read ignore files within %s -> %s", mount.Dir, err)
+		}
+
+		ls, err := DirListings(mount.Dir, ignoring(ignores, mount.validator(config.ValidPath, skips), skips), mount.mux(mux))
+
+		if err != nil {
+			return nil, fmt.Errorf("---> BindFS: Unable to create dirlisting for %s -> %s", mount.Dir, err)
+		}
+
+		listings, rules = append(listings, ls), append(rules, ignores)
 	}
 
 	bf := BindFS{
 		config:      config,
-		listing:     ls,
-		endpoint:    endpoint,
+		listings:    listings,
+		rules:       rules,
 		endpointDir: endpointDir,
-		inputDir:    input,
+		skips:       skips,
 	}
 
 	if config.Production {
@@ -131,213 +234,1118 @@ func (bfs *BindFS) ProductionMode() {
 	atomic.StoreInt64(&bfs.mode, ProductionMode)
 }
 
-// Record dumps all the files and dir listings with their corresponding data into a go file within the specified path
-func (bfs *BindFS) Record() error {
-	bfs.listing.Reload()
+// Report returns the RecordReport of the last Record or Check call, or nil if
+// none was made yet.
+func (bfs *BindFS) Report() *RecordReport {
+	bfs.reportLock.Lock()
+	defer bfs.reportLock.Unlock()
+	return bfs.report
+}
 
-	pwd, _ := os.Getwd()
-	input := bfs.inputDir
-	endpoint := bfs.endpoint
-	endpointDir := bfs.endpointDir
-	pkgHeader := fmt.Sprintf(packageDetails, bfs.config.Package, input, bfs.config.Package)
+// generatedHeader starts every file written by BindFS, only files carrying it
+// are ever removed.
+const generatedHeader = "//Auto-generated from github.com/influx6/assets"
 
-	err := os.MkdirAll(endpointDir, 0700)
+// ErrStaleBundle is returned by Check when the recorded go file differs from
+// what Record would generate.
+var ErrStaleBundle = errors.New("BindFS: recorded file is out of date")
 
-	if err != nil && err != os.ErrExist {
+// Record dumps all the files and dir listings with their corresponding data into a go file within the specified path,
+// spreading them across shard files when sharding is enabled. Shards left behind by previous runs are removed.
+// Nothing is written unless every generated go file parses, and each file replaces the previous one atomically.
+func (bfs *BindFS) Record() error {
+	files, err := bfs.renderAll()
+	if err != nil {
 		return err
 	}
 
-	//load the vfile content if not catched.
-	if bfs.vfileContent == "" {
-		vf, err := os.Open(vfile)
-		if err != nil {
+	for _, path := range sortedPaths(files) {
+		err = os.MkdirAll(filepath.Dir(path), 0700)
+
+		if err != nil && err != os.ErrExist {
 			return err
 		}
 
-		var vbuf bytes.Buffer
-		_, err = io.Copy(&vbuf, vf)
-		if err != nil && err != io.EOF {
-			vf.Close()
+		if err := writeFile(path, files[path]); err != nil {
 			return err
 		}
+	}
 
-		// Close the file.
-		vf.Close()
+	stale, err := bfs.staleFiles(files)
+	if err != nil {
+		return err
+	}
+
+	for _, path := range stale {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
 
-		bfs.vfileContent = strings.Replace(vbuf.String(), "package vfiles", "", -1)
+	return nil
+}
 
+// Check regenerates the go files in memory and returns ErrStaleBundle if they
+// differ from the ones currently recorded, leaving the recorded files untouched.
+// Modification times are left out of the comparison, as they change with every
+// checkout, so only differences in contents and modes are reported.
+func (bfs *BindFS) Check() error {
+	files, err := bfs.renderAll()
+	if err != nil {
+		return err
 	}
 
-	//remove the file for safety and to reduce bloated ouput if file was added in list
-	// os.Remove(endpoint)
+	for _, path := range sortedPaths(files) {
+		recorded, err := ioutil.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return ErrStaleBundle
+			}
+			return err
+		}
 
-	boutput, err := os.Create(endpoint)
+		if !sameOutput(path, recorded, files[path]) {
+			return ErrStaleBundle
+		}
+	}
 
-	if err != nil && err != os.ErrExist {
+	stale, err := bfs.staleFiles(files)
+	if err != nil {
 		return err
 	}
 
-	defer boutput.Close()
+	if len(stale) > 0 {
+		return ErrStaleBundle
+	}
 
-	var output = bufio.NewWriter(boutput)
+	return nil
+}
 
-	//writes the library package header
-	fmt.Fprint(output, pkgHeader)
+// staleFiles returns the generated go files, archives, manifests and staged
+// embed files within the output directory which are not part of the given
+// rendering, including those of the files recorded with or without BuildTag
+// before. Go files not carrying the generated header, archives and manifests
+// not written by BindFS and staged files not named by their digest are never
+// reported.
+func (bfs *BindFS) staleFiles(files map[string][]byte) ([]string, error) {
+	var recorded, outputs, staged []string
+
+	for _, name := range []string{bfs.config.File, bfs.config.File + devSuffix, bfs.config.File + prodSuffix} {
+		shards, err := filepath.Glob(filepath.Join(bfs.endpointDir, name+"_shard_*.go"))
+		if err != nil {
+			return nil, err
+		}
 
-	//writing the libraries core
-	fmt.Fprint(output, bfs.vfileContent)
-	fmt.Fprint(output, rootDir)
+		stages, err := filepath.Glob(filepath.Join(bfs.endpointDir, name+embedSuffix, "*"))
+		if err != nil {
+			return nil, err
+		}
 
-	var noCompressed bool
-	if bfs.Mode() > 0 {
-		if bfs.config.Gzipped && bfs.config.NoDecompression {
-			noCompressed = true
-		} else {
-			noCompressed = false
+		recorded = append(append(recorded, filepath.Join(bfs.endpointDir, name+".go")), shards...)
+		outputs = append(outputs, filepath.Join(bfs.endpointDir, name+ArchiveExt), filepath.Join(bfs.endpointDir, name+ManifestExt))
+		staged = append(staged, stages...)
+	}
+
+	var stale []string
+
+	for _, path := range recorded {
+		if _, ok := files[path]; ok {
+			continue
+		}
+
+		if generated(path) {
+			stale = append(stale, path)
 		}
 	}
 
-	fmt.Fprint(output, fmt.Sprintf(comFunc, noCompressed))
+	for _, path := range outputs {
+		if _, ok := files[path]; ok {
+			continue
+		}
+
+		if recordedOutput(path) {
+			stale = append(stale, path)
+		}
+	}
 
-	// log.Printf("tree: %s", bfs.listing.Listings.Tree)
+	for _, path := range staged {
+		if _, ok := files[path]; ok {
+			continue
+		}
 
-	//go through the directories listings
-	bfs.listing.EachDir(func(dir *BasicAssetTree, path string) {
-		// log.Printf("walking dir: %s", path)
+		if digest.MatchString(filepath.Base(path)) {
+			stale = append(stale, path)
+		}
+	}
 
-		path = filepath.ToSlash(filepath.Clean(path))
-		modDir := filepath.ToSlash(filepath.Clean(dir.ModDir))
-		pathDir := filepath.ToSlash(filepath.Clean(dir.Dir))
-		pathAbs := filepath.ToSlash(filepath.Clean(dir.AbsDir))
+	return stale, nil
+}
 
-		if path == ".." {
-			path = "/"
+// buildTag matches the names usable as build tags.
+var buildTag = regexp.MustCompile(`^[A-Za-z0-9_.]+$`)
+
+// digest matches the names files are staged under for embedding.
+var digest = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// renderTarget describes a single rendering of the bundle.
+type renderTarget struct {
+	file  string // name of the generated file without its extension
+	mode  int    // DevelopmentMode or ProductionMode
+	build string // build constraint of the generated go files, if any
+}
+
+// suffixes of the files recorded for each mode with BuildTag set
+const (
+	devSuffix  = "_dev"
+	prodSuffix = "_prod"
+)
+
+// targets returns the renderings Record writes, ending with the one of the
+// current mode so Report describes it.
+func (bfs *BindFS) targets() []renderTarget {
+	tag := bfs.config.BuildTag
+	if tag == "" {
+		return []renderTarget{{file: bfs.config.File, mode: bfs.Mode()}}
+	}
+
+	dev := renderTarget{file: bfs.config.File + devSuffix, mode: DevelopmentMode, build: "!" + tag}
+	prod := renderTarget{file: bfs.config.File + prodSuffix, mode: ProductionMode, build: tag}
+
+	if bfs.Mode() == ProductionMode {
+		return []renderTarget{dev, prod}
+	}
+
+	return []renderTarget{prod, dev}
+}
+
+// renderAll returns the generated files of every target keyed by their path.
+func (bfs *BindFS) renderAll() (map[string][]byte, error) {
+	files := make(map[string][]byte)
+
+	for _, target := range bfs.targets() {
+		rendered, err := bfs.render(target)
+		if err != nil {
+			return nil, err
 		}
 
-		// if it has a .. at the beginning, remove it.
-		if strings.HasPrefix(path, "..") {
-			path = strings.TrimPrefix(path, "..")
+		for path, data := range rendered {
+			files[path] = data
 		}
+	}
+
+	return files, nil
+}
+
+// render returns the generated go files of the target keyed by their path. Directories and
+// files are written in sorted order and all paths are kept relative to the
+// generated file, so recording an unchanged tree always yields the same output.
+//
+// Without sharding everything goes into a single file, otherwise that file only
+// holds the runtime and the root collector while the directories are registered from
+// numbered shard files. A directory whose files are split across shards is
+// registered in the first one and extended in the following ones, which is safe
+// as init functions run in the order of their file names.
+func (bfs *BindFS) render(target renderTarget) (map[string][]byte, error) {
+	report, err := bfs.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	dirs, err := bfs.bundle()
+	if err != nil {
+		return nil, err
+	}
+
+	//describe the inputs relative to the generated file to keep machine paths out of it
+	var inputs []string
+	if bfs.config.InDir != "" || len(bfs.config.Mounts) == 0 {
+		inputs = append(inputs, bfs.relative(bfs.config.InDir))
+	}
+
+	for _, mount := range bfs.config.Mounts {
+		inputs = append(inputs, bfs.relative(mount.Dir))
+	}
 
-		if modDir == ".." {
-			modDir = "/"
+	input := strings.Join(inputs, ", ")
+
+	sharded := bfs.config.ShardByDir || bfs.config.ShardSize > 0
+
+	//load the vfiles runtime embedded within this package unless the shared one is imported
+	archived := bfs.config.Archive && target.mode == ProductionMode
+	embedded := bfs.config.Embed && !archived && target.mode == ProductionMode
+
+	//files of a pair are only built along with the tag or without it
+	var constraint string
+	if target.build != "" {
+		constraint = fmt.Sprintf(buildConstraint, target.build)
+	}
+
+	endpoint := filepath.Join(bfs.endpointDir, target.file+".go")
+
+	var runtime, shardImports = sharedImport, sharedImport
+	if sharded || archived {
+		runtime = sharedRootImport
+	}
+
+	if !bfs.config.SharedRuntime {
+		runtime, err = prefixedRuntime(bfs.config.Prefix)
+		if err != nil {
+			return nil, err
 		}
+		shardImports = shardImport
+	}
+
+	var output bytes.Buffer
+
+	//writes the library package header
+	fmt.Fprint(&output, fmt.Sprintf(packageDetails, constraint, bfs.config.Package, input, bfs.config.Package))
+
+	//the embed import has to come before the declarations of the runtime
+	if embedded {
+		fmt.Fprint(&output, embedImport)
+	}
 
-		// if it has a .. at the beginning, remove it.
-		if strings.HasPrefix(modDir, "..") {
-			modDir = strings.TrimPrefix(modDir, "..")
+	//writing the libraries core
+	fmt.Fprint(&output, runtime)
+	fmt.Fprint(&output, bfs.format(rootDir))
+
+	//archived files are mounted at runtime from the archive written alongside
+	if archived {
+		archive := filepath.Join(bfs.endpointDir, target.file+ArchiveExt)
+		moduleDir := strings.Replace(strconv.Quote(bfs.moduleDir()), "%", "%%", -1)
+		loadFormat := strings.Replace(bfs.format(archiveLoad), "{{ moduleDir }}", moduleDir, -1)
+		fmt.Fprint(&output, fmt.Sprintf(loadFormat, filepath.Base(archive), bfs.config.NoDecompression))
+
+		var pack bytes.Buffer
+		if err := bfs.writeArchive(&pack, report); err != nil {
+			return nil, err
+		}
+
+		return bfs.finish(target, report, map[string][]byte{endpoint: output.Bytes(), archive: pack.Bytes()})
+	}
+
+	//embedded files are staged beside the generated file for the toolchain to store
+	stageDir := target.file + embedSuffix
+	staged := make(map[string][]byte)
+	manifest := make(map[string]string)
+
+	//production files are read and encoded by a pool of workers before being written in order
+	var encodings map[string]*encodedFile
+	if target.mode == ProductionMode {
+		paths := make(map[string]string)
+
+		for _, dir := range dirs {
+			if dir.tree != nil {
+				for _, modded := range dir.tree.Tree.Keys() {
+					paths[dir.tree.Tree.Get(modded)] = trimParent(modded)
+				}
+			}
 		}
 
-		//fill up the directory content
-		dirContent := fmt.Sprintf(dirRegister, path, modDir, pathDir, pathAbs, dir.root)
+		if encodings, err = bfs.encodeAll(paths, embedded); err != nil {
+			return nil, err
+		}
+	}
+
+	shardHeader := fmt.Sprintf(shardDetails, constraint, bfs.config.Package) + shardImports
+
+	var shards []*bytes.Buffer
+	var current = &output
+
+	newShard := func() {
+		current = bytes.NewBufferString(shardHeader)
+		shards = append(shards, current)
+	}
+
+	//files are resolved against the directory of the generated file at runtime, or its place below
+	//vfiles.BaseDirEnv, where production files are only read from when vfiles.DiskEnv asks for it
+	moduleDir := strings.Replace(strconv.Quote(bfs.moduleDir()), "%", "%%", -1)
+	var base, initFormat = "base", strings.Replace(bfs.format(fileInit), "{{ moduleDir }}", moduleDir, -1)
+
+	//keep the directories of each top-level directory together when sharding by them
+	rootPath := bundleRoot(dirs)
+
+	if bfs.config.ShardByDir {
+		sort.SliceStable(dirs, func(i, j int) bool {
+			return topDir(rootPath, dirs[i].path) < topDir(rootPath, dirs[j].path)
+		})
+	}
+
+	var lastTop string
+
+	//go through the directories listings
+	for _, dir := range dirs {
+		path := dir.path
+
+		if top := topDir(rootPath, path); sharded && (len(shards) == 0 || (bfs.config.ShardByDir && top != lastTop)) {
+			newShard()
+			lastTop = top
+		}
+
+		//fill up the directory content, directories only holding mounts have nothing on disk
+		var dirContent string
+		var fileKeys []string
+
+		if dir.tree == nil {
+			dirSec, dirNsec := bfs.modTime(nil)
+			dirContent = fmt.Sprintf(bfs.format(mountRegister), path, path, base, dir.root, dirSec, dirNsec)
+		} else {
+			dirStat, _ := os.Stat(dir.tree.Dir)
+			dirSec, dirNsec := bfs.modTime(dirStat)
+			dirContent = fmt.Sprintf(bfs.format(dirRegister), path, trimParent(dir.tree.ModDir), bfs.relative(dir.tree.Dir), base, dir.root, dirSec, dirNsec, bfs.perm(dirStat))
+			fileKeys = dir.tree.Tree.Keys()
+		}
 
 		var subs []string
 		var data []string
 
-		// go through the subdirectories list and added them
-		dir.EachChild(func(child *BasicAssetTree) {
-			childDir := filepath.ToSlash(filepath.Clean(child.ModDir))
-			baseChildDir := filepath.Base(childDir)
+		//add the sub-directories
+		for _, child := range dir.children {
+			subs = append(subs, fmt.Sprintf(bfs.format(subRegister), child.name, child.path))
+		}
 
-			if baseChildDir == ".." {
-				baseChildDir = "/"
-			}
+		dirContent = strings.Replace(dirContent, "{{ subs }}", strings.Join(subs, "\n"), -1)
 
-			// if it has a .. at the beginning, remove it.
-			// if strings.HasPrefix(baseChildDir, "..") {
-			// 	baseChildDir = strings.TrimPrefix(baseChildDir, "..")
-			// }
+		var pending int
+		var registered bool
 
-			if strings.HasPrefix(childDir, "..") {
-				childDir = strings.TrimPrefix(childDir, "..")
+		//writes the files gathered so far, registering the directory with the first of them
+		flush := func() {
+			content := fmt.Sprintf(bfs.format(dirExtend), path)
+			if !registered {
+				content, registered = dirContent, true
 			}
 
-			// if it contains the output skip
-			//add the sub-directories
-			subs = append(subs, fmt.Sprintf(subRegister, baseChildDir, childDir))
-		})
+			content = strings.Replace(content, "{{ files }}", strings.Join(data, "\n"), -1)
+			fmt.Fprint(current, fmt.Sprintf(initFormat, content))
+
+			data, pending = nil, 0
+		}
 
 		//loadup the files
-		dir.Tree.Each(func(modded, real string) {
+		for _, modded := range fileKeys {
+			real := dir.tree.Tree.Get(modded)
 			modded = filepath.ToSlash(filepath.Clean(modded))
-			real = filepath.ToSlash(filepath.Clean(real))
-			cleanPwd := filepath.ToSlash(filepath.Clean(pwd))
 
 			// if it has a .. at the beginning, remove it.
 			if strings.HasPrefix(modded, "..") {
 				modded = strings.TrimPrefix(modded, "..")
 			}
 
-			var output string
-			if bfs.Mode() == DevelopmentMode {
-				stat, _ := os.Stat(filepath.Join(pwd, real))
-				var filreadFunc = fileRead
-				var size int64
-
-				if stat != nil {
-					size = stat.Size()
+			var file string
+			if target.mode == DevelopmentMode {
+				stat, err := os.Stat(real)
+				if err != nil {
+					report.addSkip(bfs.relative(real), SkipReadError, err)
+					continue
 				}
 
-				if bfs.config.Gzipped && bfs.config.NoDecompression {
-					filreadFunc = comfileRead
-				}
+				var filreadFunc = bfs.format(fileRead)
+				var size = stat.Size()
 
-				output = fmt.Sprintf(debugFile, cleanPwd, modded, real, size, bfs.config.Gzipped, !bfs.config.NoDecompression, filreadFunc)
-			} else {
-				//production mode is active,we need to load the file contents
+				// files are compressed as they are read with the first codec
+				var codec string
+				var compressed = bfs.compressible(real, size)
 
-				file, err := os.Open(real)
+				if compressed {
+					codec = bfs.codecs()[0].Name()
 
-				if err != nil {
-					fmt.Printf("---> BindFS.error: failed to loadup %s file -> %s", real, err)
-					return
+					if bfs.config.NoDecompression {
+						filreadFunc = bfs.format(comfileRead)
+					}
 				}
 
-				var data bytes.Buffer
-				var writer io.WriteCloser
+				// the checksum is left out so it is computed from the file on disk when asked for, as is
+				// the modification time, the recorded one only standing in when the file is missing
+				sec, nsec := bfs.modTime(stat)
+				file = fmt.Sprintf(bfs.format(debugFile), base, modded, bfs.relative(real), size, compressed, !bfs.config.NoDecompression, filreadFunc, "", codec, sec, nsec, bfs.perm(stat), liveAssign+bfs.fileTransforms(modded))
+				report.addFile(ReportFile{Path: modded, Source: bfs.relative(real), Size: size, Codec: codec})
+			} else {
+				//production mode is active, the file contents were encoded ahead of time
+				encoded := encodings[real]
 
-				if bfs.config.Gzipped {
-					writer = createCompressWriter(&data)
-				} else {
-					writer = createUnCompressWriter(&data)
+				if encoded.err != nil {
+					report.addSkip(bfs.relative(real), SkipReadError, encoded.err)
+					continue
 				}
 
-				n, _ := io.Copy(writer, file)
-				file.Close()
-				writer.Close()
+				chunk := encoded.chunk
+				sec, nsec := bfs.modTime(encoded.info)
 
-				var bu []byte
+				var format string
 
-				if bfs.config.Gzipped {
-					bu = sanitize(data.Bytes())
+				// files are staged under the digest of what is stored, so identical files share one
+				if embedded {
+					staged[filepath.Join(bfs.endpointDir, stageDir, chunk.Staged())] = chunk.stored
+					format = fmt.Sprintf(bfs.format(embedRead), stageDir+"/"+chunk.Staged())
 				} else {
-					bu = data.Bytes()
+					format = fmt.Sprintf(bfs.format(prodRead), chunk.Literal())
 				}
 
-				var format string
-				if bfs.config.Gzipped {
-					stringed := fmt.Sprintf("%q", bu)
-					stringed = strings.Replace(stringed, `\\`, `\`, -1)
-					format = fmt.Sprintf(prodRead, stringed)
-				} else {
-					format = fmt.Sprintf(prodRead, fmt.Sprintf("`%s`", bu))
+				// fingerprinted files are published under their new name, with the old one kept as an alias
+				// and the name on disk kept for reading them from there
+				published, extras := modded, bfs.fileTransforms(modded)
+				if bfs.config.Fingerprint {
+					published = fingerprinted(modded, chunk.checksum)
+					extras += fmt.Sprintf(shadowAssign, filepath.Base(real))
+					extras += fmt.Sprintf(aliasAdd, baseName(modded), baseName(published))
+					manifest[rooted(modded)] = rooted(published)
 				}
 
-				output = fmt.Sprintf(debugFile, cleanPwd, modded, real, n, bfs.config.Gzipped, !bfs.config.NoDecompression, format)
+				file = fmt.Sprintf(bfs.format(debugFile), base, published, bfs.relative(real), chunk.size, chunk.codec != "", !bfs.config.NoDecompression, format, chunk.checksum, chunk.codec, sec, nsec, bfs.perm(encoded.info), extras)
+				report.addFile(ReportFile{Path: modded, Published: manifest[rooted(modded)], Source: bfs.relative(real), Size: chunk.size, StoredSize: int64(len(chunk.stored)), Codec: chunk.codec, Checksum: chunk.checksum, Transforms: encoded.transforms})
 			}
 
-			data = append(data, output)
-		})
+			//move on to a new shard once the current one would outgrow the shard size
+			if size := bfs.config.ShardSize; size > 0 && current.Len()+pending > len(shardHeader) && int64(current.Len()+pending+len(file)) > size {
+				if len(data) > 0 || !registered {
+					flush()
+				}
+				newShard()
+			}
 
-		dirContent = strings.Replace(dirContent, "{{ subs }}", strings.Join(subs, "\n"), -1)
-		dirContent = strings.Replace(dirContent, "{{ files }}", strings.Join(data, "\n"), -1)
+			data = append(data, file)
+			pending += len(file)
+		}
+
+		if len(data) > 0 || !registered {
+			flush()
+		}
+	}
+
+	//the manifest lists every fingerprinted file, which none are in development mode
+	if bfs.config.Fingerprint {
+		var logical []string
+		for name := range manifest {
+			logical = append(logical, name)
+		}
+
+		sort.Strings(logical)
+
+		var entries bytes.Buffer
+		for _, name := range logical {
+			fmt.Fprintf(&entries, manifestEntry, name, manifest[name])
+		}
+
+		fmt.Fprint(&output, fmt.Sprintf(bfs.format(manifestVar), entries.String()))
+	}
+
+	//the embed directive goes last, as the pattern must only be written once files are staged
+	if embedded {
+		directive := fmt.Sprintf(embedDirective, stageDir)
+		if len(staged) == 0 {
+			directive = ""
+		}
+
+		fmt.Fprint(&output, fmt.Sprintf(bfs.format(embedFiles), directive))
+	}
+
+	files := map[string][]byte{endpoint: output.Bytes()}
+	for path, data := range staged {
+		files[path] = data
+	}
+
+	width := len(strconv.Itoa(len(shards)))
+	if width < 3 {
+		width = 3
+	}
+
+	for index, shard := range shards {
+		name := fmt.Sprintf("%s_shard_%0*d.go", target.file, width, index+1)
+		files[filepath.Join(bfs.endpointDir, name)] = shard.Bytes()
+	}
+
+	return bfs.finish(target, report, files)
+}
+
+// embedSuffix is appended to the file name to name the directory files are
+// staged in for embedding.
+const embedSuffix = "_assets"
+
+// ArchiveExt is the extension of the archive recorded next to the generated
+// go file in archive mode.
+const ArchiveExt = ".assets"
+
+// WriteArchive writes the directories and files as a zip archive into the
+// writer, which the generated package of an archive mode BindFS mounts at
+// runtime from beside or the end of its executable (see vfiles.AppendArchive).
+// Entries are named relative to the root directory named by the archive
+// comment, and each file records its checksum and its source relative to the
+// generated file in its own comment. Unless the BindFS is lenient, a
+// RecordError is returned once the archive is written if some paths could not
+// be read.
+func (bfs *BindFS) WriteArchive(w io.Writer) error {
+	report, err := bfs.reload()
+	if err != nil {
+		return err
+	}
+
+	if err := bfs.writeArchive(w, report); err != nil {
+		return err
+	}
+
+	if bfs.config.Lenient {
+		return nil
+	}
+
+	return report.failures()
+}
 
-		fmt.Fprint(output, fmt.Sprintf(rootInit, dirContent))
+// writeArchive writes the archive of the loaded listing, adding every archived
+// file to the report.
+func (bfs *BindFS) writeArchive(w io.Writer, report *RecordReport) error {
+	dirs, err := bfs.bundle()
+	if err != nil {
+		return err
+	}
+
+	root := bundleRoot(dirs)
+	archive := zip.NewWriter(w)
+
+	var entries []*zip.FileHeader
+
+	// zip archives only know deflate, which is what the flate codec writes
+	codec := bfs.flateCodec()
+	archive.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return codec.Encoder(w)
 	})
 
-	// io.Copy(boutput, output)
-	// log.Printf("flushing to file")
-	output.Flush()
+	if err := archive.SetComment(root); err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		var fileKeys []string
+		var stat os.FileInfo
+		var mode os.FileMode = 0755
+
+		if dir.tree != nil {
+			stat, _ = os.Stat(dir.tree.Dir)
+			mode = bfs.perm(stat)
+			fileKeys = dir.tree.Tree.Keys()
+		}
+
+		if name := archiveName(root, dir.path); name != "" {
+			header := &zip.FileHeader{Name: name + "/", Method: zip.Store, Modified: bfs.modified(stat)}
+			header.SetMode(os.ModeDir | mode)
+
+			if _, err := archive.CreateHeader(header); err != nil {
+				return err
+			}
+		}
+
+		for _, modded := range fileKeys {
+			real := dir.tree.Tree.Get(modded)
+
+			content, err := ioutil.ReadFile(real)
+			if err != nil {
+				report.addSkip(bfs.relative(real), SkipReadError, err)
+				continue
+			}
+
+			content, transforms, err := bfs.transform(trimParent(modded), content)
+			if err != nil {
+				return err
+			}
+
+			stat, _ := os.Stat(real)
+			hash := sha256.Sum256(content)
+			checksum := hex.EncodeToString(hash[:])
+
+			//the source follows the checksum so the file can be read from disk
+			header := &zip.FileHeader{
+				Name:     archiveName(root, trimParent(modded)),
+				Comment:  checksum + " " + filepath.ToSlash(bfs.relative(real)),
+				Method:   zip.Store,
+				Modified: bfs.modified(stat),
+			}
+			header.SetMode(bfs.perm(stat))
+
+			if bfs.compressible(real, int64(len(content))) {
+				compressed, err := vfiles.Encode(codec, content)
+				if err != nil {
+					return fmt.Errorf("---> BindFS: failed to compress %s -> %s", real, err)
+				}
+
+				if bfs.config.Compression == nil || bfs.config.Compression.Worth(len(content), len(compressed)) {
+					header.Method = zip.Deflate
+				}
+			}
+
+			writer, err := archive.CreateHeader(header)
+			if err != nil {
+				return err
+			}
+
+			if _, err := writer.Write(content); err != nil {
+				return err
+			}
+
+			entries = append(entries, header)
+			report.addFile(ReportFile{Path: trimParent(modded), Source: bfs.relative(real), Size: int64(len(content)), Codec: codecName(header), Checksum: checksum, Transforms: transforms})
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+
+	// the stored sizes are only known once the entries were written out
+	for index, header := range entries {
+		report.Files[len(report.Files)-len(entries)+index].StoredSize = int64(header.CompressedSize64)
+	}
 
 	return nil
 }
+
+// codecName returns the codec an archive entry is compressed with.
+func codecName(header *zip.FileHeader) string {
+	if header.Method == zip.Deflate {
+		return "flate"
+	}
+	return ""
+}
+
+// flateCodec returns the flate codec among the configured codecs, so its
+// level is used for the entries of archives.
+func (bfs *BindFS) flateCodec() vfiles.Codec {
+	for _, codec := range bfs.codecs() {
+		if codec.Name() == "flate" {
+			return codec
+		}
+	}
+
+	return vfiles.FlateCodec{}
+}
+
+// modified returns the modification time to record for a file within an
+// archive, the zero time when there is none.
+func (bfs *BindFS) modified(info os.FileInfo) time.Time {
+	sec, nsec := bfs.modTime(info)
+	if sec == 0 && nsec == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(sec, nsec).UTC()
+}
+
+// archiveName returns the name of the entry for a virtual path within an
+// archive rooted at root.
+func archiveName(root, path string) string {
+	path = strings.Trim(path, "/")
+
+	switch root = strings.Trim(root, "/"); {
+	case path == root || path == ".":
+		return ""
+	case root == "" || root == ".":
+		return path
+	}
+
+	return strings.TrimPrefix(path, root+"/")
+}
+
+// reload reloads the listings, returning a report holding the paths they left
+// out this time.
+func (bfs *BindFS) reload() (*RecordReport, error) {
+	bfs.skips.take()
+
+	for _, rules := range bfs.rules {
+		if err := rules.Reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, listing := range bfs.listings {
+		if err := listing.Reload(); err != nil {
+			return nil, err
+		}
+	}
+
+	var report RecordReport
+
+	for _, skip := range bfs.skips.take() {
+		skip.Path = bfs.relative(skip.Path)
+		report.Skipped = append(report.Skipped, skip)
+	}
+
+	return &report, nil
+}
+
+// finish completes the report of a render, keeping it for Report and adding
+// it as a manifest to the rendered files when one is asked for. Unless the
+// BindFS is lenient, paths which could not be read fail the render.
+func (bfs *BindFS) finish(target renderTarget, report *RecordReport, files map[string][]byte) (map[string][]byte, error) {
+	report.finish()
+
+	bfs.reportLock.Lock()
+	bfs.report = report
+	bfs.reportLock.Unlock()
+
+	if !bfs.config.Lenient {
+		if err := report.failures(); err != nil {
+			return nil, err
+		}
+	}
+
+	for path, data := range files {
+		if filepath.Ext(path) != ".go" {
+			continue
+		}
+
+		formatted, err := formatSource(path, data)
+		if err != nil {
+			return nil, err
+		}
+
+		files[path] = formatted
+	}
+
+	if bfs.config.Manifest {
+		manifest, err := report.manifest()
+		if err != nil {
+			return nil, err
+		}
+
+		files[filepath.Join(bfs.endpointDir, target.file+ManifestExt)] = manifest
+	}
+
+	return files, nil
+}
+
+// codecs returns the codecs files get compressed with.
+func (bfs *BindFS) codecs() []vfiles.Codec {
+	if len(bfs.config.Codecs) == 0 {
+		return []vfiles.Codec{vfiles.GzipCodec{}}
+	}
+	return bfs.config.Codecs
+}
+
+// compressible returns true if the file at the path with the given size should
+// be compressed.
+func (bfs *BindFS) compressible(path string, size int64) bool {
+	if !bfs.config.Gzipped {
+		return false
+	}
+
+	if bfs.config.Compression == nil {
+		return true
+	}
+
+	return bfs.config.Compression.Allows(path, size)
+}
+
+// compress returns the data compressed with whichever codec produces the
+// smallest output, along with the name of that codec.
+func (bfs *BindFS) compress(data []byte) ([]byte, string, error) {
+	var best []byte
+	var name string
+
+	for _, codec := range bfs.codecs() {
+		compressed, err := vfiles.Encode(codec, data)
+		if err != nil {
+			return nil, "", err
+		}
+
+		if best == nil || len(compressed) < len(best) {
+			best, name = compressed, codec.Name()
+		}
+	}
+
+	return best, name, nil
+}
+
+// modTime returns the modification time to record for a file as unix seconds
+// and nanoseconds, using the pinned ModTime when one is configured.
+func (bfs *BindFS) modTime(info os.FileInfo) (int64, int64) {
+	mod := bfs.config.ModTime
+
+	if mod.IsZero() && info != nil {
+		mod = info.ModTime()
+	}
+
+	if mod.IsZero() {
+		return 0, 0
+	}
+
+	return mod.Unix(), int64(mod.Nanosecond())
+}
+
+// perm returns the permission bits to record for a file.
+func (bfs *BindFS) perm(info os.FileInfo) os.FileMode {
+	if info == nil {
+		return 0
+	}
+
+	return info.Mode().Perm()
+}
+
+// relative returns the slashed path of a file or directory relative to the
+// directory of the generated file.
+func (bfs *BindFS) relative(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return filepath.ToSlash(filepath.Clean(path))
+	}
+
+	rel, err := filepath.Rel(bfs.endpointDir, abs)
+	if err != nil {
+		return filepath.ToSlash(abs)
+	}
+
+	return filepath.ToSlash(rel)
+}
+
+// format returns the given code format with references to the vfiles runtime
+// qualified as needed for the generated file.
+func (bfs *BindFS) format(code string) string {
+	// the copied runtime carries the prefix on its names, which are all exported
+	qualifier := strings.TrimSuffix(prefixed(bfs.config.Prefix, "X"), "X")
+	if bfs.config.SharedRuntime {
+		qualifier = "vfiles."
+	}
+
+	root := bfs.config.Root
+	if root == "" {
+		root = DefaultRoot
+	}
+
+	return strings.NewReplacer(
+		"{{ vfiles }}", qualifier,
+		"{{ root }}", root,
+		"{{ ArchiveError }}", prefixed(bfs.config.Prefix, "ArchiveError"),
+		"{{ embeddedFiles }}", prefixed(bfs.config.Prefix, "embeddedFiles"),
+		"{{ Manifest }}", prefixed(bfs.config.Prefix, "Manifest"),
+	).Replace(code)
+}
+
+// ignoring returns a PathValidator turning down the paths the rules leave out,
+// recording them as ignored, before handing the others to valid.
+func ignoring(rules *IgnoreRules, valid PathValidator, skips *skipList) PathValidator {
+	return func(path string, in os.FileInfo) bool {
+		if rules.Ignored(path, in != nil && in.IsDir()) {
+			skips.add(filepath.ToSlash(path), SkipIgnored)
+			return false
+		}
+
+		return valid(path, in)
+	}
+}
+
+// within returns true if the path is the directory or lies inside it.
+func within(dir, path string) bool {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return false
+	}
+
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+// trimParent cleans a listing path, turning the .. prefix listings give paths
+// into the root of the virtual tree.
+func trimParent(path string) string {
+	path = filepath.ToSlash(filepath.Clean(path))
+
+	if path == ".." {
+		return "/"
+	}
+
+	// if it has a .. at the beginning, remove it.
+	return strings.TrimPrefix(path, "..")
+}
+
+// topDir returns the directory right below the root a virtual path lies in,
+// which is empty for the root itself.
+func topDir(root, path string) string {
+	root, path = strings.Trim(root, "/"), strings.Trim(path, "/")
+
+	switch {
+	case path == root || path == ".":
+		return ""
+	case root != "" && root != ".":
+		path = strings.TrimPrefix(path, root+"/")
+	}
+
+	return strings.SplitN(path, "/", 2)[0]
+}
+
+// moduleDir returns the directory of the generated file relative to the root
+// of its module, or "." when it is not within one.
+func (bfs *BindFS) moduleDir() string {
+	for dir := bfs.endpointDir; ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			rel, err := filepath.Rel(dir, bfs.endpointDir)
+			if err != nil {
+				break
+			}
+
+			return filepath.ToSlash(rel)
+		}
+
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	return "."
+}
+
+// formatSource returns the generated go file gofmt'd, failing if it does not
+// parse.
+func formatSource(path string, data []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+
+	file, err := parser.ParseFile(fset, filepath.Base(path), data, parser.ParseComments|parser.AllErrors)
+	if err != nil {
+		return nil, fmt.Errorf("---> BindFS: generated invalid go for %s -> %s", filepath.Base(path), err)
+	}
+
+	ast.SortImports(fset, file)
+
+	var out bytes.Buffer
+	if err := format.Node(&out, fset, file); err != nil {
+		return nil, fmt.Errorf("---> BindFS: unable to format %s -> %s", filepath.Base(path), err)
+	}
+
+	return out.Bytes(), nil
+}
+
+// writeFile writes the data into a temporary file beside the path before
+// renaming it over the path, so the file is either left as it was or fully
+// replaced.
+func writeFile(path string, data []byte) error {
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	// the temporary file is gone once renamed, so this only cleans up failures
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		return err
+	}
+
+	if err := temp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(temp.Name(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
+}
+
+// sortedPaths returns the paths of the rendered files in sorted order.
+func sortedPaths(files map[string][]byte) []string {
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+	return paths
+}
+
+// modStamp matches the modification times written into generated go files.
+var modStamp = regexp.MustCompile(`time\.Unix\(-?\d+, *-?\d+\)`)
+
+// sameOutput returns true if the recorded file at the path only differs from
+// its rendering by the modification times it holds.
+func sameOutput(path string, recorded, rendered []byte) bool {
+	if bytes.Equal(recorded, rendered) {
+		return true
+	}
+
+	switch {
+	case strings.HasSuffix(path, ".go"):
+		mask := []byte("time.Unix(0, 0)")
+		return bytes.Equal(modStamp.ReplaceAll(recorded, mask), modStamp.ReplaceAll(rendered, mask))
+	case strings.HasSuffix(path, ArchiveExt):
+		return sameArchive(recorded, rendered)
+	}
+
+	return false
+}
+
+// sameArchive returns true if both archives hold the same entries with the
+// same contents and modes, whatever their modification times.
+func sameArchive(recorded, rendered []byte) bool {
+	left, err := zip.NewReader(bytes.NewReader(recorded), int64(len(recorded)))
+	if err != nil {
+		return false
+	}
+
+	right, err := zip.NewReader(bytes.NewReader(rendered), int64(len(rendered)))
+	if err != nil {
+		return false
+	}
+
+	if left.Comment != right.Comment || len(left.File) != len(right.File) {
+		return false
+	}
+
+	for index, entry := range left.File {
+		other := right.File[index]
+
+		if entry.Name != other.Name || entry.Comment != other.Comment || entry.Method != other.Method || entry.Mode() != other.Mode() ||
+			entry.CRC32 != other.CRC32 || entry.UncompressedSize64 != other.UncompressedSize64 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// recordedOutput returns true if the file at the path is an archive or a
+// manifest as BindFS writes them.
+func recordedOutput(path string) bool {
+	if strings.HasSuffix(path, ArchiveExt) {
+		archive, err := zip.OpenReader(path)
+		if err != nil {
+			return false
+		}
+
+		archive.Close()
+		return true
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return false
+	}
+
+	_, listed := fields["files"]
+	_, totaled := fields["totals"]
+	return listed && totaled
+}
+
+// generated returns true if the file at the path starts with the header
+// BindFS writes.
+func generated(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+
+	defer file.Close()
+
+	header := make([]byte, len(generatedHeader))
+	if _, err := io.ReadFull(file, header); err != nil {
+		return false
+	}
+
+	return string(header) == generatedHeader
+}
@@ -0,0 +1,1734 @@
+package assets
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/influx6/assets/vfiles"
+	"github.com/influx6/flux"
+)
+
+// tempOutDir returns a temporary directory relative to the working directory,
+// as BindFS resolves its OutDir against it.
+func tempOutDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "bindfs")
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create temporary dir: %s", err)
+	}
+
+	pwd, _ := os.Getwd()
+	rel, err := filepath.Rel(pwd, dir)
+	if err != nil {
+		flux.FatalFailed(t, "Unable to relate temporary dir: %s", err)
+	}
+
+	return rel
+}
+
+func TestBindFSCheck(t *testing.T) {
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	for _, production := range []bool{false, true} {
+		bf, err := NewBindFS(&BindFSConfig{
+			InDir:      "./fixtures",
+			OutDir:     out,
+			Package:    "fixtures",
+			File:       "fixtures",
+			Gzipped:    true,
+			Production: production,
+		})
+
+		if err != nil {
+			flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+		}
+
+		if err := bf.Check(); err != ErrStaleBundle {
+			flux.FatalFailed(t, "Expected missing file to be stale but got %v", err)
+		}
+
+		if err := bf.Record(); err != nil {
+			flux.FatalFailed(t, "Unable to record: %s", err)
+		}
+
+		if err := bf.Check(); err != nil {
+			flux.FatalFailed(t, "Expected recorded file to be up to date but got %v", err)
+		}
+
+		recorded, err := ioutil.ReadFile(filepath.Join(out, "fixtures.go"))
+		if err != nil {
+			flux.FatalFailed(t, "Unable to read recorded file: %s", err)
+		}
+
+		pwd, _ := os.Getwd()
+		if strings.Contains(string(recorded), filepath.ToSlash(pwd)) {
+			flux.FatalFailed(t, "Expected recorded file to contain no absolute paths")
+		}
+
+		files, err := bf.renderAll()
+		if err != nil {
+			flux.FatalFailed(t, "Unable to render: %s", err)
+		}
+
+		if len(files) != 1 {
+			flux.FatalFailed(t, "Expected a single file without sharding but got %d", len(files))
+		}
+
+		for _, output := range files {
+			if !bytes.Equal(recorded, output) {
+				flux.FatalFailed(t, "Expected rendering an unchanged tree to be byte for byte stable")
+			}
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(out, "fixtures.go"), append(recorded, '\n'), 0600); err != nil {
+			flux.FatalFailed(t, "Unable to modify recorded file: %s", err)
+		}
+
+		if err := bf.Check(); err != ErrStaleBundle {
+			flux.FatalFailed(t, "Expected modified file to be stale but got %v", err)
+		}
+	}
+
+	flux.LogPassed(t, "Recorded files are stable and checked for drift")
+}
+
+func TestBindFSCheckModTimes(t *testing.T) {
+	in := tempOutDir(t)
+	defer os.RemoveAll(in)
+
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	source := filepath.Join(in, "app.css")
+	if err := ioutil.WriteFile(source, []byte("body {}"), 0600); err != nil {
+		flux.FatalFailed(t, "Unable to write file: %s", err)
+	}
+
+	for _, config := range []BindFSConfig{{}, {Production: true}, {Production: true, Archive: true}} {
+		config.InDir, config.OutDir, config.Package, config.File = in, out, "static", "static"
+
+		bf, err := NewBindFS(&config)
+		if err != nil {
+			flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+		}
+
+		if err := bf.Record(); err != nil {
+			flux.FatalFailed(t, "Unable to record: %s", err)
+		}
+
+		// touching the files, as a fresh checkout does, changes nothing they hold
+		touched := time.Now().Add(-time.Hour)
+		for _, path := range []string{in, source} {
+			if err := os.Chtimes(path, touched, touched); err != nil {
+				flux.FatalFailed(t, "Unable to touch %s: %s", path, err)
+			}
+		}
+
+		if err := bf.Check(); err != nil {
+			flux.FatalFailed(t, "Expected touched files to be up to date but got %v", err)
+		}
+
+		if err := ioutil.WriteFile(source, []byte("body { color: red }"), 0600); err != nil {
+			flux.FatalFailed(t, "Unable to write file: %s", err)
+		}
+
+		if err := bf.Check(); err != ErrStaleBundle {
+			flux.FatalFailed(t, "Expected changed contents to be stale but got %v", err)
+		}
+
+		if err := ioutil.WriteFile(source, []byte("body {}"), 0600); err != nil {
+			flux.FatalFailed(t, "Unable to write file: %s", err)
+		}
+	}
+
+	flux.LogPassed(t, "Check ignores modification times")
+}
+
+func TestBindFSValidatedWrites(t *testing.T) {
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	config := &BindFSConfig{InDir: "./fixtures", OutDir: out, Package: "fixtures", File: "fixtures"}
+
+	bf, err := NewBindFS(config)
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	recorded, err := ioutil.ReadFile(filepath.Join(out, "fixtures.go"))
+	if err != nil {
+		flux.FatalFailed(t, "Unable to read recorded file: %s", err)
+	}
+
+	if formatted, err := format.Source(recorded); err != nil || !bytes.Equal(formatted, recorded) {
+		flux.FatalFailed(t, "Expected the recorded file to be gofmt'd")
+	}
+
+	// a package name which is not an identifier can not parse
+	config.Package = "not-valid"
+
+	if bf, err = NewBindFS(config); err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Record(); err == nil {
+		flux.FatalFailed(t, "Expected a generated file which does not parse to fail the recording")
+	}
+
+	after, err := ioutil.ReadFile(filepath.Join(out, "fixtures.go"))
+	if err != nil || !bytes.Equal(after, recorded) {
+		flux.FatalFailed(t, "Expected a failed recording to leave the previous file in place")
+	}
+
+	if entries, _ := ioutil.ReadDir(out); len(entries) != 1 {
+		flux.FatalFailed(t, "Expected no temporary files to be left behind but got %d files", len(entries))
+	}
+
+	flux.LogPassed(t, "Generated files are validated before they replace recorded ones")
+}
+
+func TestBindFSRelocatable(t *testing.T) {
+	module := tempOutDir(t)
+	defer os.RemoveAll(module)
+
+	if err := ioutil.WriteFile(filepath.Join(module, "go.mod"), []byte("module example.com/app\n"), 0600); err != nil {
+		flux.FatalFailed(t, "Unable to write go.mod: %s", err)
+	}
+
+	bf, err := NewBindFS(&BindFSConfig{InDir: "./fixtures", OutDir: filepath.Join(module, "web", "static"), Package: "static", File: "static"})
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	recorded, err := ioutil.ReadFile(filepath.Join(module, "web", "static", "static.go"))
+	if err != nil {
+		flux.FatalFailed(t, "Unable to read recorded file: %s", err)
+	}
+
+	if !strings.Contains(string(recorded), `var base = ResolveBase(CallerDir(), "web/static")`) {
+		flux.FatalFailed(t, "Expected the recorded file to know its place within the module")
+	}
+
+	pwd, _ := os.Getwd()
+	if strings.Contains(string(recorded), filepath.ToSlash(pwd)) {
+		flux.FatalFailed(t, "Expected recorded file to contain no absolute paths")
+	}
+
+	flux.LogPassed(t, "Development files are found relative to their module")
+}
+
+func TestBindFSShards(t *testing.T) {
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	config := &BindFSConfig{
+		InDir:      "./fixtures",
+		OutDir:     out,
+		Package:    "fixtures",
+		File:       "fixtures",
+		Production: true,
+		ShardByDir: true,
+	}
+
+	bf, err := NewBindFS(config)
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	// a file which only looks like a shard must be left alone
+	foreign := filepath.Join(out, "fixtures_shard_999.go")
+	if err := ioutil.WriteFile(foreign, []byte("package fixtures\n"), 0600); err != nil {
+		flux.FatalFailed(t, "Unable to write file: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	shards, _ := filepath.Glob(filepath.Join(out, "fixtures_shard_*.go"))
+	if len(shards) < 3 {
+		flux.FatalFailed(t, "Expected a shard for every top-level directory but got %q", shards)
+	}
+
+	if err := bf.Check(); err != nil {
+		flux.FatalFailed(t, "Expected sharded files to be up to date but got %v", err)
+	}
+
+	config.ShardByDir = false
+	config.ShardSize = 1
+
+	if err := bf.Check(); err != ErrStaleBundle {
+		flux.FatalFailed(t, "Expected changed sharding to be stale but got %v", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	sized, _ := filepath.Glob(filepath.Join(out, "fixtures_shard_*.go"))
+	if len(sized) <= len(shards) {
+		flux.FatalFailed(t, "Expected a shard for every file once shards hold a byte but got %q", sized)
+	}
+
+	config.ShardSize = 0
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	left, _ := filepath.Glob(filepath.Join(out, "fixtures_shard_*.go"))
+	if len(left) != 1 || left[0] != foreign {
+		flux.FatalFailed(t, "Expected stale shards to be removed but found %q", left)
+	}
+
+	flux.LogPassed(t, "Recorded files are sharded and stale shards cleaned up")
+}
+
+func TestBindFSStaleOutputs(t *testing.T) {
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	config := &BindFSConfig{InDir: "./fixtures", OutDir: out, Package: "fixtures", File: "fixtures", Production: true, Archive: true, Manifest: true}
+
+	bf, err := NewBindFS(config)
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	archive, manifest := filepath.Join(out, "fixtures"+ArchiveExt), filepath.Join(out, "fixtures"+ManifestExt)
+	for _, path := range []string{archive, manifest} {
+		if _, err := os.Stat(path); err != nil {
+			flux.FatalFailed(t, "Expected %s to be recorded: %s", path, err)
+		}
+	}
+
+	config.Archive, config.Manifest = false, false
+
+	bf, err = NewBindFS(config)
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Check(); err != ErrStaleBundle {
+		flux.FatalFailed(t, "Expected a left over archive to be stale but got %v", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	for _, path := range []string{archive, manifest} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			flux.FatalFailed(t, "Expected stale %s to be removed", path)
+		}
+	}
+
+	// files of the same name not written by BindFS are left alone
+	if err := ioutil.WriteFile(archive, []byte("not an archive"), 0600); err != nil {
+		flux.FatalFailed(t, "Unable to write file: %s", err)
+	}
+
+	if err := ioutil.WriteFile(manifest, []byte(`{"name":"fixtures"}`), 0600); err != nil {
+		flux.FatalFailed(t, "Unable to write file: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	for _, path := range []string{archive, manifest} {
+		if _, err := os.Stat(path); err != nil {
+			flux.FatalFailed(t, "Expected unrelated files to be kept: %s", err)
+		}
+	}
+
+	flux.LogPassed(t, "Stale archives and manifests are cleaned up")
+}
+
+func TestBindFSArchive(t *testing.T) {
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	bf, err := NewBindFS(&BindFSConfig{
+		InDir:      "./fixtures",
+		OutDir:     out,
+		Package:    "fixtures",
+		File:       "fixtures",
+		Gzipped:    true,
+		Production: true,
+		Archive:    true,
+	})
+
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	recorded, err := ioutil.ReadFile(filepath.Join(out, "fixtures.go"))
+	if err != nil {
+		flux.FatalFailed(t, "Unable to read recorded file: %s", err)
+	}
+
+	if bytes.Contains(recorded, []byte("RootDirectory.Set(")) || !bytes.Contains(recorded, []byte("LoadArchive(RootDirectory")) {
+		flux.FatalFailed(t, "Expected archive mode to mount the archive instead of embedding files")
+	}
+
+	// stand in for an executable the archive gets appended to
+	exe := filepath.Join(out, "server")
+	if err := ioutil.WriteFile(exe, []byte("#!/bin/sh\necho server\n"), 0700); err != nil {
+		flux.FatalFailed(t, "Unable to write executable: %s", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		archive, err := os.Open(filepath.Join(out, "fixtures"+ArchiveExt))
+		if err != nil {
+			flux.FatalFailed(t, "Unable to open archive: %s", err)
+		}
+
+		err = vfiles.AppendArchive(exe, archive)
+		archive.Close()
+
+		if err != nil {
+			flux.FatalFailed(t, "Unable to append archive: %s", err)
+		}
+	}
+
+	reader, err := vfiles.OpenArchive(exe)
+	if err != nil {
+		flux.FatalFailed(t, "Unable to open appended archive: %s", err)
+	}
+
+	root := vfiles.NewDirCollector()
+	if err := vfiles.MountArchive(root, reader, vfiles.ArchiveOptions{}); err != nil {
+		flux.FatalFailed(t, "Unable to mount archive: %s", err)
+	}
+
+	file, err := root.GetFile("/fixtures/base/basic.tmpl")
+	if err != nil {
+		flux.FatalFailed(t, "Unable to find archived file: %s", err)
+	}
+
+	data, err := file.Data()
+	if err != nil {
+		flux.FatalFailed(t, "Unable to read archived file: %s", err)
+	}
+
+	original, _ := ioutil.ReadFile("./fixtures/base/basic.tmpl")
+	if !bytes.Equal(data, original) {
+		flux.FatalFailed(t, "Expected archived file to match its source")
+	}
+
+	if hash, _ := file.Hash(); hash != fmt.Sprintf("%x", sha256.Sum256(original)) {
+		flux.FatalFailed(t, "Expected archived file to carry its checksum but got %q", hash)
+	}
+
+	if _, err := root.GetDir("/fixtures/layouts"); err != nil {
+		flux.FatalFailed(t, "Unable to find archived directory: %s", err)
+	}
+
+	flux.LogPassed(t, "Archived files are appended to and mounted from executables")
+}
+
+func TestBindFSArchiveOptions(t *testing.T) {
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	bf, err := NewBindFS(&BindFSConfig{
+		InDir:           "./fixtures",
+		OutDir:          out,
+		Package:         "fixtures",
+		File:            "fixtures",
+		Gzipped:         true,
+		NoDecompression: true,
+		Production:      true,
+		Archive:         true,
+	})
+
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	recorded, err := ioutil.ReadFile(filepath.Join(out, "fixtures.go"))
+	if err != nil {
+		flux.FatalFailed(t, "Unable to read recorded file: %s", err)
+	}
+
+	if !bytes.Contains(recorded, []byte("NoDecompress: true")) {
+		flux.FatalFailed(t, "Expected the archive to be loaded without decompression")
+	}
+
+	reader, err := vfiles.OpenArchive(filepath.Join(out, "fixtures"+ArchiveExt))
+	if err != nil {
+		flux.FatalFailed(t, "Unable to open archive: %s", err)
+	}
+
+	original, _ := ioutil.ReadFile("./fixtures/base/basic.tmpl")
+
+	root := vfiles.NewDirCollector()
+	if err := vfiles.MountArchive(root, reader, vfiles.ArchiveOptions{BaseDir: out, NoDecompress: true}); err != nil {
+		flux.FatalFailed(t, "Unable to mount archive: %s", err)
+	}
+
+	file, err := root.GetFile("/fixtures/base/basic.tmpl")
+	if err != nil {
+		flux.FatalFailed(t, "Unable to find archived file: %s", err)
+	}
+
+	data, err := file.Data()
+	if err != nil {
+		flux.FatalFailed(t, "Unable to read archived file: %s", err)
+	}
+
+	if decoded, err := vfiles.Decode(vfiles.FlateCodec{}, data); err != nil || !bytes.Equal(decoded, original) {
+		flux.FatalFailed(t, "Expected the archived file compressed as stored but got %q: %v", data, err)
+	}
+
+	if !file.Compressed || file.Decompress || file.CodecName() != "flate" {
+		flux.FatalFailed(t, "Expected the archived file to report its flate compression but got %q", file.CodecName())
+	}
+
+	// the sources are found relative to the generated file
+	base, _ := filepath.Abs(out)
+
+	root = vfiles.NewDirCollector()
+	if err := vfiles.MountArchive(root, reader, vfiles.ArchiveOptions{BaseDir: base}); err != nil {
+		flux.FatalFailed(t, "Unable to mount archive: %s", err)
+	}
+
+	file, _ = root.GetFile("/fixtures/base/basic.tmpl")
+	if source, _ := filepath.Abs("./fixtures/base/basic.tmpl"); file.RealPath() != source {
+		flux.FatalFailed(t, "Expected the archived file to point at %s but got %s", source, file.RealPath())
+	}
+
+	// moving the base shows the file is read from disk rather than the archive
+	root = vfiles.NewDirCollector()
+	if err := vfiles.MountArchive(root, reader, vfiles.ArchiveOptions{BaseDir: filepath.Join(base, "a", "b")}); err != nil {
+		flux.FatalFailed(t, "Unable to mount archive: %s", err)
+	}
+
+	file, _ = root.GetFile("/fixtures/base/basic.tmpl")
+	os.MkdirAll(filepath.Dir(file.RealPath()), 0755)
+
+	if err := ioutil.WriteFile(file.RealPath(), []byte("edited"), 0644); err != nil {
+		flux.FatalFailed(t, "Unable to write file: %s", err)
+	}
+
+	os.Setenv(vfiles.DiskEnv, "1")
+	defer os.Unsetenv(vfiles.DiskEnv)
+
+	if data, err := file.Data(); err != nil || string(data) != "edited" {
+		flux.FatalFailed(t, "Expected the archived file read from disk but got %q: %v", data, err)
+	}
+
+	flux.LogPassed(t, "Archived files honour NoDecompression and ASSETS_FROM_DISK")
+}
+
+func TestBindFSMounts(t *testing.T) {
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	config := &BindFSConfig{
+		OutDir:     out,
+		Package:    "fixtures",
+		File:       "fixtures",
+		Production: true,
+		Mounts: []Mount{
+			{Dir: "./fixtures/layouts", Prefix: "/"},
+			{Dir: "./fixtures/base", Prefix: "/static"},
+			{Dir: "./fixtures/includes", Prefix: "/vendor/lib", Ignore: regexp.MustCompile("^index")},
+		},
+	}
+
+	bf, err := NewBindFS(config)
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	var pack bytes.Buffer
+	if err := bf.WriteArchive(&pack); err != nil {
+		flux.FatalFailed(t, "Unable to write archive: %s", err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(pack.Bytes()), int64(pack.Len()))
+	if err != nil {
+		flux.FatalFailed(t, "Unable to read archive: %s", err)
+	}
+
+	root := vfiles.NewDirCollector()
+	if err := vfiles.MountArchive(root, reader, vfiles.ArchiveOptions{}); err != nil {
+		flux.FatalFailed(t, "Unable to mount archive: %s", err)
+	}
+
+	for _, path := range []string{"/basic.tmpl", "/static/basic.tmpl", "/static/index.tmpl"} {
+		if _, err := root.GetFile(path); err != nil {
+			flux.FatalFailed(t, "Unable to find mounted file %s: %s", path, err)
+		}
+	}
+
+	if _, err := root.GetDir("/vendor/lib"); err != nil {
+		flux.FatalFailed(t, "Unable to find mounted directory: %s", err)
+	}
+
+	if _, err := root.GetFile("/vendor/lib/index.tmpl"); err == nil {
+		flux.FatalFailed(t, "Expected the ignore rules of the mount to apply")
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	recorded, err := ioutil.ReadFile(filepath.Join(out, "fixtures.go"))
+	if err != nil {
+		flux.FatalFailed(t, "Unable to read recorded file: %s", err)
+	}
+
+	// the directory holding nothing but the mount is registered all the same
+	if !bytes.Contains(recorded, []byte(`RootDirectory.Set("vendor",`)) {
+		flux.FatalFailed(t, "Expected the parent of a mount to be registered")
+	}
+
+	clash, err := NewBindFS(&BindFSConfig{
+		OutDir:  out,
+		Package: "fixtures",
+		File:    "fixtures",
+		Mounts: []Mount{
+			{Dir: "./fixtures/base", Prefix: "/pages"},
+			{Dir: "./fixtures/includes", Prefix: "/pages"},
+		},
+	})
+
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := clash.Record(); err == nil || !strings.Contains(err.Error(), "both map to virtual path") {
+		flux.FatalFailed(t, "Expected mounts sharing a virtual path to fail but got %v", err)
+	}
+
+	flux.LogPassed(t, "Mounted directories are bundled under their prefixes")
+}
+
+func TestBindFSStripPrefix(t *testing.T) {
+	cases := []struct {
+		strip, mount, path, virtual string
+	}{
+		{"./public", "", "./public", "/"},
+		{"./public", "", "public/css/app.css", "css/app.css"},
+		{"./public", "/static", "public/css", "static/css"},
+		{"./public", "", "other/app.css", "other/app.css"},
+		{"../.", "", "../.", "/"},
+		{"../.", "", "../fixtures/base/basic.tmpl", "fixtures/base/basic.tmpl"},
+		{"", "static/", "fixtures", "static/fixtures"},
+	}
+
+	for _, c := range cases {
+		if virtual := rewriteMux(c.strip, c.mount, nil)(c.path, nil); virtual != c.virtual {
+			flux.FatalFailed(t, "Expected %s to be rewritten to %s but got %s", c.path, c.virtual, virtual)
+		}
+	}
+
+	// generate from within the fixtures like tests/generate.go does from within tests
+	pwd, _ := os.Getwd()
+	if err := os.Chdir(filepath.Join("fixtures", "base")); err != nil {
+		flux.FatalFailed(t, "Unable to change directory: %s", err)
+	}
+
+	defer os.Chdir(pwd)
+
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	listing, err := DirListingsAt("../.", nil, nil, "../.", "")
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create dirlisting: %s", err)
+	}
+
+	keys := listing.Listings.Keys()
+	sort.Strings(keys)
+
+	if strings.Join(keys, ",") != "/,base,includes,layouts" {
+		flux.FatalFailed(t, "Expected directory keys to be stripped but got %q", keys)
+	}
+
+	if dir := listing.Listings.Get("base"); dir == nil || !dir.Tree.Has("base/basic.tmpl") {
+		flux.FatalFailed(t, "Expected file paths to be stripped like their directories")
+	}
+
+	config := &BindFSConfig{
+		InDir:       "../.",
+		OutDir:      out,
+		Package:     "fixtures",
+		File:        "fixtures",
+		Production:  true,
+		StripPrefix: "../.",
+		MountAt:     "/static",
+	}
+
+	bf, err := NewBindFS(config)
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	var pack bytes.Buffer
+	if err := bf.WriteArchive(&pack); err != nil {
+		flux.FatalFailed(t, "Unable to write archive: %s", err)
+	}
+
+	reader, err := zip.NewReader(bytes.NewReader(pack.Bytes()), int64(pack.Len()))
+	if err != nil {
+		flux.FatalFailed(t, "Unable to read archive: %s", err)
+	}
+
+	root := vfiles.NewDirCollector()
+	if err := vfiles.MountArchive(root, reader, vfiles.ArchiveOptions{}); err != nil {
+		flux.FatalFailed(t, "Unable to mount archive: %s", err)
+	}
+
+	if _, err := root.GetFile("/static/base/basic.tmpl"); err != nil {
+		flux.FatalFailed(t, "Unable to find rewritten file: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	recorded, err := ioutil.ReadFile(filepath.Join(out, "fixtures.go"))
+	if err != nil {
+		flux.FatalFailed(t, "Unable to read recorded file: %s", err)
+	}
+
+	if !bytes.Contains(recorded, []byte(`RootDirectory.Set("static/base",`)) || bytes.Contains(recorded, []byte(`RootDirectory.Set("..`)) {
+		flux.FatalFailed(t, "Expected directories to be registered under their rewritten paths")
+	}
+
+	flux.LogPassed(t, "Paths are stripped and mounted consistently")
+}
+
+func TestBindFSPrefix(t *testing.T) {
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	bundles := []*BindFSConfig{
+		{InDir: "./fixtures/base", File: "templates", Root: "Templates", Prefix: "Tmpl"},
+		{InDir: "./fixtures/layouts", File: "static", Root: "Static", Prefix: "static", Production: true},
+	}
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+
+	for _, config := range bundles {
+		config.OutDir, config.Package = out, "fixtures"
+
+		bf, err := NewBindFS(config)
+		if err != nil {
+			flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+		}
+
+		if err := bf.Record(); err != nil {
+			flux.FatalFailed(t, "Unable to record: %s", err)
+		}
+
+		file, err := parser.ParseFile(fset, filepath.Join(out, config.File+".go"), nil, 0)
+		if err != nil {
+			flux.FatalFailed(t, "Unable to parse recorded file: %s", err)
+		}
+
+		files = append(files, file)
+	}
+
+	// both bundles have to type check as a single package
+	checker := types.Config{Importer: importer.ForCompiler(fset, "source", nil)}
+	pkg, err := checker.Check("fixtures", fset, files, nil)
+	if err != nil {
+		flux.FatalFailed(t, "Expected bundles to share a package but got %s", err)
+	}
+
+	for _, name := range []string{"Templates", "Static", "TmplVDir", "StaticVDir", "StaticReadData", "staticCodecLevel"} {
+		if pkg.Scope().Lookup(name) == nil {
+			flux.FatalFailed(t, "Expected %s to be declared", name)
+		}
+	}
+
+	if _, err := NewBindFS(&BindFSConfig{InDir: "./fixtures", OutDir: out, Package: "fixtures", File: "fixtures", Root: "not valid"}); err == nil {
+		flux.FatalFailed(t, "Expected an invalid root name to be turned down")
+	}
+
+	flux.LogPassed(t, "Bundles with their own root and prefix share a package")
+}
+
+func TestBindFSBuildTag(t *testing.T) {
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	config := &BindFSConfig{
+		InDir:   "./fixtures",
+		OutDir:  out,
+		Package: "fixtures",
+		File:    "fixtures",
+	}
+
+	bf, err := NewBindFS(config)
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	config.BuildTag = "assets_embed"
+
+	bf, err = NewBindFS(config)
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(out, "fixtures.go")); !os.IsNotExist(err) {
+		flux.FatalFailed(t, "Expected the untagged file to be removed")
+	}
+
+	constraints := map[string]string{"fixtures_dev.go": "!assets_embed", "fixtures_prod.go": "assets_embed"}
+
+	for name, constraint := range constraints {
+		file, err := parser.ParseFile(token.NewFileSet(), filepath.Join(out, name), nil, parser.ParseComments)
+		if err != nil {
+			flux.FatalFailed(t, "Unable to parse recorded file: %s", err)
+		}
+
+		if len(file.Comments) < 2 || file.Comments[1].Text() != "" || file.Comments[1].List[0].Text != "//go:build "+constraint {
+			flux.FatalFailed(t, "Expected %s to be built with %q", name, constraint)
+		}
+
+		if file.Scope.Lookup("RootDirectory") == nil {
+			flux.FatalFailed(t, "Expected %s to declare RootDirectory", name)
+		}
+	}
+
+	if err := bf.Check(); err != nil {
+		flux.FatalFailed(t, "Expected a freshly recorded pair to pass the check but got %s", err)
+	}
+
+	if _, err := NewBindFS(&BindFSConfig{InDir: "./fixtures", OutDir: out, Package: "fixtures", File: "fixtures", BuildTag: "not valid"}); err == nil {
+		flux.FatalFailed(t, "Expected an invalid build tag to be turned down")
+	}
+
+	flux.LogPassed(t, "Recorded development and production files switched by a build tag")
+}
+
+func TestBindFSEmbed(t *testing.T) {
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	config := &BindFSConfig{
+		InDir:      "./fixtures",
+		OutDir:     out,
+		Package:    "fixtures",
+		File:       "fixtures",
+		Gzipped:    true,
+		Production: true,
+		Embed:      true,
+	}
+
+	bf, err := NewBindFS(config)
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	recorded, err := ioutil.ReadFile(filepath.Join(out, "fixtures.go"))
+	if err != nil {
+		flux.FatalFailed(t, "Unable to read recorded file: %s", err)
+	}
+
+	if !bytes.Contains(recorded, []byte("//go:embed fixtures_assets\n")) {
+		flux.FatalFailed(t, "Expected recorded file to embed the staged files")
+	}
+
+	// the layouts and base directories hold identical files, which share a staged file
+	staged, _ := filepath.Glob(filepath.Join(out, "fixtures_assets", "*"))
+	if len(staged) != 3 {
+		flux.FatalFailed(t, "Expected 3 staged files for 4 files but got %q", staged)
+	}
+
+	if err := bf.Check(); err != nil {
+		flux.FatalFailed(t, "Expected staged files to be up to date but got %v", err)
+	}
+
+	config.Embed = false
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	if left, _ := filepath.Glob(filepath.Join(out, "fixtures_assets", "*")); len(left) != 0 {
+		flux.FatalFailed(t, "Expected staged files to be removed but found %q", left)
+	}
+
+	flux.LogPassed(t, "Files are staged for go:embed and cleaned up once unused")
+}
+
+func TestBindFSCache(t *testing.T) {
+	in := tempOutDir(t)
+	defer os.RemoveAll(in)
+
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	style := filepath.Join(in, "style.css")
+	for _, name := range []string{"style.css", "copy.css"} {
+		if err := ioutil.WriteFile(filepath.Join(in, name), []byte("body { color: red; }"), 0600); err != nil {
+			flux.FatalFailed(t, "Unable to write file: %s", err)
+		}
+	}
+
+	bf, err := NewBindFS(&BindFSConfig{
+		InDir:      in,
+		OutDir:     out,
+		Package:    "static",
+		File:       "static",
+		Gzipped:    true,
+		Production: true,
+		Workers:    4,
+	})
+
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	if len(bf.cache.files) != 2 || len(bf.cache.chunks) != 1 {
+		flux.FatalFailed(t, "Expected identical files to share one chunk but got %d", len(bf.cache.chunks))
+	}
+
+	first := bf.cache.chunks[bf.cache.files[style].key]
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	if bf.cache.chunks[bf.cache.files[style].key] != first {
+		flux.FatalFailed(t, "Expected unchanged file to reuse its chunk")
+	}
+
+	if err := ioutil.WriteFile(style, []byte("body { color: blue; }"), 0600); err != nil {
+		flux.FatalFailed(t, "Unable to write file: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	if bf.cache.chunks[bf.cache.files[style].key] == first || len(bf.cache.chunks) != 2 {
+		flux.FatalFailed(t, "Expected changed file to be encoded again")
+	}
+
+	if err := bf.Check(); err != nil {
+		flux.FatalFailed(t, "Expected recorded file to be up to date but got %v", err)
+	}
+
+	flux.LogPassed(t, "Unchanged files reuse the chunks encoded before")
+}
+
+func TestBindFSReport(t *testing.T) {
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	bf, err := NewBindFS(&BindFSConfig{
+		InDir:      "./fixtures",
+		OutDir:     out,
+		Package:    "fixtures",
+		File:       "fixtures",
+		Gzipped:    true,
+		Production: true,
+		Manifest:   true,
+		Ignore:     regexp.MustCompile("includes"),
+		ValidPath: func(path string, _ os.FileInfo) bool {
+			return filepath.Base(path) != "index.tmpl"
+		},
+	})
+
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	report := bf.Report()
+	if report == nil {
+		flux.FatalFailed(t, "Expected a report of the recording")
+	}
+
+	if report.Totals.Files != 2 || len(report.Files) != 2 {
+		flux.FatalFailed(t, "Expected 2 files to be embedded but got %+v", report.Files)
+	}
+
+	for _, file := range report.Files {
+		if file.Codec != "gzip" || file.StoredSize >= file.Size || len(file.Checksum) != 64 {
+			flux.FatalFailed(t, "Expected embedded file to be described but got %+v", file)
+		}
+	}
+
+	reasons := make(map[SkipReason]int)
+	for _, skip := range report.Skipped {
+		reasons[skip.Reason]++
+	}
+
+	if reasons[SkipIgnored] != 1 || reasons[SkipRejected] != 1 {
+		flux.FatalFailed(t, "Expected ignored and rejected paths to be reported but got %+v", report.Skipped)
+	}
+
+	manifest, err := ioutil.ReadFile(filepath.Join(out, "fixtures"+ManifestExt))
+	if err != nil {
+		flux.FatalFailed(t, "Unable to read manifest: %s", err)
+	}
+
+	var recorded RecordReport
+	if err := json.Unmarshal(manifest, &recorded); err != nil {
+		flux.FatalFailed(t, "Unable to decode manifest: %s", err)
+	}
+
+	if !reflect.DeepEqual(recorded.Totals, report.Totals) {
+		flux.FatalFailed(t, "Expected manifest to hold the report but got %+v", recorded.Totals)
+	}
+
+	if err := bf.Check(); err != nil {
+		flux.FatalFailed(t, "Expected manifest to be up to date but got %v", err)
+	}
+
+	flux.LogPassed(t, "Records report what was embedded and left out")
+}
+
+func TestBindFSStrict(t *testing.T) {
+	in := tempOutDir(t)
+	defer os.RemoveAll(in)
+
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	if err := ioutil.WriteFile(filepath.Join(in, "a.txt"), []byte("a"), 0600); err != nil {
+		flux.FatalFailed(t, "Unable to write file: %s", err)
+	}
+
+	// a dangling link is listed like a file but can never be read
+	if err := os.Symlink(filepath.Join(in, "missing.txt"), filepath.Join(in, "gone.txt")); err != nil {
+		flux.FatalFailed(t, "Unable to create link: %s", err)
+	}
+
+	for _, production := range []bool{false, true} {
+		config := &BindFSConfig{InDir: in, OutDir: out, Package: "fixtures", File: "fixtures", Production: production}
+
+		bf, err := NewBindFS(config)
+		if err != nil {
+			flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+		}
+
+		err = bf.Record()
+
+		failed, ok := err.(*RecordError)
+		if !ok || len(failed.Failures) != 1 || !strings.HasSuffix(failed.Failures[0].Path, "gone.txt") || failed.Failures[0].Error == "" {
+			flux.FatalFailed(t, "Expected the unreadable file to fail the recording but got %v", err)
+		}
+
+		if _, err := os.Stat(filepath.Join(out, "fixtures.go")); !os.IsNotExist(err) {
+			flux.FatalFailed(t, "Expected nothing to be recorded after a failure")
+		}
+
+		config.Lenient = true
+
+		if bf, err = NewBindFS(config); err != nil {
+			flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+		}
+
+		if err := bf.Record(); err != nil {
+			flux.FatalFailed(t, "Expected a lenient recording to succeed but got %s", err)
+		}
+
+		report := bf.Report()
+		if report.Totals.Files != 1 || len(report.Skipped) != 1 || report.Skipped[0].Reason != SkipReadError {
+			flux.FatalFailed(t, "Expected the unreadable file to be reported but got %+v", report)
+		}
+
+		os.Remove(filepath.Join(out, "fixtures.go"))
+	}
+
+	flux.LogPassed(t, "Unreadable files fail recordings unless lenient")
+}
+
+func TestBindFSTransformers(t *testing.T) {
+	in := tempOutDir(t)
+	defer os.RemoveAll(in)
+
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	files := map[string]string{
+		"css/app.css": "/* layout */\na {\n  color: red;\n}\n",
+		"data.json":   "{ \"a\": [1, 2] }\n",
+		"notes.txt":   "as is",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(in, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			flux.FatalFailed(t, "Unable to create dir: %s", err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			flux.FatalFailed(t, "Unable to write file: %s", err)
+		}
+	}
+
+	upper := Transformer{Name: "upper", Match: []string{"*.txt"}, Func: func(path string, data []byte) ([]byte, error) {
+		return bytes.ToUpper(data), nil
+	}}
+
+	config := &BindFSConfig{
+		InDir:        in,
+		OutDir:       out,
+		Package:      "fixtures",
+		File:         "fixtures",
+		Production:   true,
+		Transformers: append(append([]Transformer{}, MinifyTransformers...), upper),
+	}
+
+	bf, err := NewBindFS(config)
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	expected := map[string]string{
+		"css/app.css": "a{color:red}",
+		"data.json":   `{"a":[1,2]}`,
+		"notes.txt":   "AS IS",
+	}
+
+	report := bf.Report()
+	if len(report.Files) != len(expected) {
+		flux.FatalFailed(t, "Expected %d files but got %d", len(expected), len(report.Files))
+	}
+
+	for _, file := range report.Files {
+		for name, content := range expected {
+			if !strings.HasSuffix(file.Path, "/"+name) {
+				continue
+			}
+
+			if sum := sha256.Sum256([]byte(content)); file.Checksum != fmt.Sprintf("%x", sum) || file.Size != int64(len(content)) {
+				flux.FatalFailed(t, "Expected %s to be embedded as %q", name, content)
+			}
+
+			if len(file.Transforms) != 1 || file.Transforms[0].Saved != int64(len(files[name])-len(content)) {
+				flux.FatalFailed(t, "Expected %s to report the bytes saved but got %+v", name, file.Transforms)
+			}
+		}
+	}
+
+	if saved := report.Totals.Saved["minify-css"]; saved != int64(len(files["css/app.css"])-len(expected["css/app.css"])) {
+		flux.FatalFailed(t, "Expected the totals to sum up the bytes saved but got %d", saved)
+	}
+
+	// a changed function is picked up by the next record even for unchanged files
+	checksum := func(name string) string {
+		for _, file := range bf.Report().Files {
+			if strings.HasSuffix(file.Path, "/"+name) {
+				return file.Checksum
+			}
+		}
+		return ""
+	}
+
+	lower := func(path string, data []byte) ([]byte, error) {
+		return bytes.ToLower(data), nil
+	}
+
+	changed := &config.Transformers[len(config.Transformers)-1]
+
+	// functions without a version always run, versioned ones run again once it changes
+	for _, step := range []struct {
+		fn       vfiles.TransformFunc
+		version  string
+		expected string
+	}{
+		{lower, "", "as is"},
+		{upper.Func, "1", "AS IS"},
+		{lower, "1", "AS IS"},
+		{lower, "2", "as is"},
+	} {
+		changed.Func, changed.Version = step.fn, step.version
+
+		if err := bf.Record(); err != nil {
+			flux.FatalFailed(t, "Unable to record: %s", err)
+		}
+
+		if sum := sha256.Sum256([]byte(step.expected)); checksum("notes.txt") != fmt.Sprintf("%x", sum) {
+			flux.FatalFailed(t, "Expected notes.txt to be embedded as %q at version %q", step.expected, step.version)
+		}
+	}
+
+	config.Transformers[len(config.Transformers)-1] = upper
+
+	config.Production, config.DevTransforms = false, true
+
+	bf, err = NewBindFS(config)
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	recorded, err := ioutil.ReadFile(filepath.Join(out, "fixtures.go"))
+	if err != nil {
+		flux.FatalFailed(t, "Unable to read recorded file: %s", err)
+	}
+
+	if !strings.Contains(string(recorded), `file.Transforms = []string{"minify-css"}`) {
+		flux.FatalFailed(t, "Expected development files to name their transforms")
+	}
+
+	if _, err := NewBindFS(&BindFSConfig{InDir: in, OutDir: out, Package: "fixtures", Transformers: []Transformer{{Name: "unknown"}}}); err == nil {
+		flux.FatalFailed(t, "Expected a transformer without a function to be turned down")
+	}
+
+	flux.LogPassed(t, "Transformers rewrite files before they are embedded")
+}
+
+func TestBindFSFingerprint(t *testing.T) {
+	in := tempOutDir(t)
+	defer os.RemoveAll(in)
+
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	files := map[string]string{
+		"css/app.css":  "@import 'base.css';\nbody { background: url(\"../img/bg.png?v=1\") }\n",
+		"css/base.css": "a { background: url(https://example.com/a.png) }\n",
+		"img/bg.png":   "png",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(in, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			flux.FatalFailed(t, "Unable to create dir: %s", err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			flux.FatalFailed(t, "Unable to write file: %s", err)
+		}
+	}
+
+	config := &BindFSConfig{
+		InDir:       in,
+		OutDir:      out,
+		Package:     "fixtures",
+		File:        "fixtures",
+		Production:  true,
+		Fingerprint: true,
+	}
+
+	bf, err := NewBindFS(config)
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	checksum := func(content string) string {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(content)))
+	}
+
+	bg := "bg." + checksum("png")[:8] + ".png"
+	base := "base." + checksum(files["css/base.css"])[:8] + ".css"
+	app := "@import '" + base + "';\nbody { background: url(\"../img/" + bg + "?v=1\") }\n"
+
+	published := map[string]string{
+		"css/app.css":  "app." + checksum(app)[:8] + ".css",
+		"css/base.css": base,
+		"img/bg.png":   bg,
+	}
+
+	for _, file := range bf.Report().Files {
+		for name, expected := range published {
+			if strings.HasSuffix(file.Path, "/"+name) && file.Published != rooted(path.Join(path.Dir(file.Path), expected)) {
+				flux.FatalFailed(t, "Expected %s to be published as %s but got %s", name, expected, file.Published)
+			}
+		}
+	}
+
+	recorded, err := ioutil.ReadFile(filepath.Join(out, "fixtures.go"))
+	if err != nil {
+		flux.FatalFailed(t, "Unable to read recorded file: %s", err)
+	}
+
+	for _, expected := range []string{"var Manifest = AssetManifest{", fmt.Sprintf("dir.AddAlias(%q, %q)", "app.css", published["css/app.css"])} {
+		if !strings.Contains(string(recorded), expected) {
+			flux.FatalFailed(t, "Expected recorded file to contain %s", expected)
+		}
+	}
+
+	if _, err := NewBindFS(&BindFSConfig{InDir: in, OutDir: out, Package: "fixtures", Fingerprint: true, Archive: true}); err == nil {
+		flux.FatalFailed(t, "Expected fingerprinting archives to be turned down")
+	}
+
+	flux.LogPassed(t, "Fingerprinted files are published under their checksum")
+}
+
+func TestBindFSFingerprintFromDisk(t *testing.T) {
+	gobin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+
+	in := tempOutDir(t)
+	defer os.RemoveAll(in)
+
+	// the program has to be within the module to import vfiles, where go ./... skips _ directories
+	out, err := ioutil.TempDir(".", "_bindfs")
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create dir: %s", err)
+	}
+
+	defer os.RemoveAll(out)
+
+	source := filepath.Join(in, "app.css")
+	if err := ioutil.WriteFile(source, []byte("body {}"), 0600); err != nil {
+		flux.FatalFailed(t, "Unable to write file: %s", err)
+	}
+
+	bf, err := NewBindFS(&BindFSConfig{InDir: in, StripPrefix: in, OutDir: out, Package: "main", File: "fixtures", Production: true, Fingerprint: true, SharedRuntime: true})
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	var published string
+	for _, file := range bf.Report().Files {
+		published = file.Published
+	}
+
+	if !strings.HasSuffix(published, ".css") || strings.HasSuffix(published, "/app.css") {
+		flux.FatalFailed(t, "Expected app.css to be fingerprinted but got %s", published)
+	}
+
+	program := `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	file, err := RootDirectory.GetFile(os.Args[1])
+	if err != nil {
+		panic(err)
+	}
+
+	data, err := file.Data()
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Print(string(data))
+}
+`
+
+	if err := ioutil.WriteFile(filepath.Join(out, "main.go"), []byte(program), 0600); err != nil {
+		flux.FatalFailed(t, "Unable to write program: %s", err)
+	}
+
+	// the file changes on disk after recording, so reading it from there shows
+	if err := ioutil.WriteFile(source, []byte("from disk"), 0600); err != nil {
+		flux.FatalFailed(t, "Unable to change file: %s", err)
+	}
+
+	cmd := exec.Command(gobin, "run", "./"+filepath.ToSlash(out), published)
+	cmd.Env = append(os.Environ(), vfiles.DiskEnv+"=1")
+
+	data, err := cmd.CombinedOutput()
+	if err != nil {
+		flux.FatalFailed(t, "Unable to read fingerprinted file from disk: %s -> %s", err, data)
+	}
+
+	if string(data) != "from disk" {
+		flux.FatalFailed(t, "Expected fingerprinted file to be read from disk but got %s", data)
+	}
+
+	flux.LogPassed(t, "Fingerprinted files are read from disk under their source name")
+}
+
+func TestIgnoreRules(t *testing.T) {
+	in := tempOutDir(t)
+	defer os.RemoveAll(in)
+
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	files := map[string]string{
+		".gitignore":        "# build output\n*.log\n!keep.log\nbuild/\n/top.txt\ndocs/**/*.md\n",
+		"sub/.assetsignore": "secret*\n",
+		"a.log":             "a",
+		"keep.log":          "keep",
+		"build/app.js":      "app",
+		"top.txt":           "top",
+		"sub/top.txt":       "top",
+		"sub/secret.txt":    "secret",
+		"secret.txt":        "secret",
+		"docs/a/b/c.md":     "c",
+		"docs/readme.txt":   "readme",
+	}
+
+	for name, content := range files {
+		path := filepath.Join(in, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			flux.FatalFailed(t, "Unable to create dir: %s", err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			flux.FatalFailed(t, "Unable to write file: %s", err)
+		}
+	}
+
+	rules, err := LoadIgnoreRules(in)
+	if err != nil {
+		flux.FatalFailed(t, "Unable to load ignore rules: %s", err)
+	}
+
+	expected := map[string]bool{
+		".git":              true,
+		".gitignore":        true,
+		"a.log":             true,
+		"keep.log":          false,
+		"build/app.js":      true,
+		"top.txt":           true,
+		"sub/top.txt":       false,
+		"sub/secret.txt":    true,
+		"secret.txt":        false,
+		"docs/a/b/c.md":     true,
+		"docs/readme.txt":   false,
+		"sub/build/deep.js": true,
+	}
+
+	for path, ignored := range expected {
+		if rules.Match(path, path == ".git") != ignored {
+			flux.FatalFailed(t, "Expected %s to be ignored: %t", path, ignored)
+		}
+	}
+
+	tree, err := AssetTree(in, nil, []string{"docs/"})
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create asset map: %s", err)
+	}
+
+	var names []string
+	for name := range tree {
+		names = append(names, filepath.ToSlash(name))
+	}
+
+	sort.Strings(names)
+
+	if strings.Join(names, ",") != "keep.log,secret.txt,sub/top.txt" {
+		flux.FatalFailed(t, "Expected the asset map to honour the ignore rules but got %q", names)
+	}
+
+	bf, err := NewBindFS(&BindFSConfig{
+		InDir:          in,
+		OutDir:         out,
+		Package:        "fixtures",
+		File:           "fixtures",
+		IgnorePatterns: []string{"sub/", "docs/", "keep.log"},
+	})
+
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	if err := bf.Record(); err != nil {
+		flux.FatalFailed(t, "Unable to record: %s", err)
+	}
+
+	var paths []string
+	for _, file := range bf.Report().Files {
+		paths = append(paths, filepath.Base(file.Path))
+	}
+
+	// the configured patterns take precedence over the ignore files
+	if strings.Join(paths, ",") != "secret.txt" {
+		flux.FatalFailed(t, "Expected BindFS to honour the ignore rules but got %q", paths)
+	}
+
+	// ignore files above the root are read up to the root of the project
+	project := tempOutDir(t)
+	defer os.RemoveAll(project)
+
+	above := map[string]string{
+		"go.mod":           "module example.com/app\n",
+		".assetsignore":    "*.secret\npublic/*.tmp\n",
+		"public/a.secret":  "a",
+		"public/b.tmp":     "b",
+		"public/index.css": "c",
+	}
+
+	for name, content := range above {
+		path := filepath.Join(project, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			flux.FatalFailed(t, "Unable to create dir: %s", err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			flux.FatalFailed(t, "Unable to write file: %s", err)
+		}
+	}
+
+	rules, err = LoadIgnoreRules(filepath.Join(project, "public"), "!a.secret")
+	if err != nil {
+		flux.FatalFailed(t, "Unable to load ignore rules: %s", err)
+	}
+
+	for path, ignored := range map[string]bool{"a.secret": false, "b.tmp": true, "index.css": false, "sub/c.secret": true} {
+		if rules.Match(path, false) != ignored {
+			flux.FatalFailed(t, "Expected %s within the project to be ignored: %t", path, ignored)
+		}
+	}
+
+	flux.LogPassed(t, "Ignore rules follow gitignore semantics")
+}
+
+func TestCompressionPolicy(t *testing.T) {
+	policy := DefaultCompressionPolicy
+
+	if policy.Allows("./public/logo.PNG", 4096) {
+		flux.FatalFailed(t, "Expected png files to be left uncompressed")
+	}
+
+	if policy.Allows("./public/intro.mp4", 4096) {
+		flux.FatalFailed(t, "Expected video files to be left uncompressed")
+	}
+
+	if policy.Allows("./public/app.css", 10) {
+		flux.FatalFailed(t, "Expected tiny files to be left uncompressed")
+	}
+
+	if !policy.Allows("./public/app.css", 4096) {
+		flux.FatalFailed(t, "Expected css files to be compressed")
+	}
+
+	if policy.Worth(1000, 990) {
+		flux.FatalFailed(t, "Expected compression saving 1%% to be dropped")
+	}
+
+	if !policy.Worth(1000, 400) {
+		flux.FatalFailed(t, "Expected compression saving 60%% to be kept")
+	}
+
+	flux.LogPassed(t, "Compression policy turns down incompressible files")
+}
+
+func TestLiteral(t *testing.T) {
+	text, err := ioutil.ReadFile("./fixtures/base/basic.tmpl")
+	if err != nil {
+		flux.FatalFailed(t, "Unable to read fixture: %s", err)
+	}
+
+	var large []byte
+	for i := 0; i < 64; i++ {
+		large = append(large, text...)
+	}
+
+	gzipped, err := vfiles.Encode(vfiles.GzipCodec{}, large)
+	if err != nil {
+		flux.FatalFailed(t, "Unable to compress fixture: %s", err)
+	}
+
+	// the fixtures use windows line endings, which raw literals would drop.
+	unix := bytes.Replace(text, []byte("\r\n"), []byte("\n"), -1)
+
+	samples := [][]byte{
+		nil,
+		text,
+		unix,
+		gzipped,
+		[]byte("`quoted`\r\n\x00\xef\xbb\xbf\xff\u2028\"end\\"),
+	}
+
+	for _, sample := range samples {
+		lit := literal(sample)
+
+		value, err := types.Eval(token.NewFileSet(), nil, token.NoPos, lit)
+		if err != nil {
+			flux.FatalFailed(t, "Expected a valid go expression for %q: %s", sample, err)
+		}
+
+		if constant.StringVal(value.Value) != string(sample) {
+			flux.FatalFailed(t, "Expected literal to decode back into %q", sample)
+		}
+	}
+
+	// the previous encoding escaped every byte before quoting the result.
+	var escaped bytes.Buffer
+	(&StringWriter{W: &escaped}).Write(gzipped)
+	previous := strings.Replace(fmt.Sprintf("%q", escaped.Bytes()), `\\`, `\`, -1)
+
+	if len(literal(gzipped)) >= len(previous) {
+		flux.FatalFailed(t, "Expected %d bytes for compressed data to be fewer than the %d bytes written before", len(literal(gzipped)), len(previous))
+	}
+
+	if len(literal(unix)) != len(unix)+2 {
+		flux.FatalFailed(t, "Expected text to be written as a single raw literal")
+	}
+
+	flux.LogPassed(t, "Literals are compact and decode back into their data")
+}
+
+func TestBindFSWatch(t *testing.T) {
+	in := tempOutDir(t)
+	defer os.RemoveAll(in)
+
+	out := tempOutDir(t)
+	defer os.RemoveAll(out)
+
+	if err := ioutil.WriteFile(filepath.Join(in, "app.css"), []byte("body { color: red; }"), 0600); err != nil {
+		flux.FatalFailed(t, "Unable to write fixture: %s", err)
+	}
+
+	bf, err := NewBindFS(&BindFSConfig{
+		InDir:          in,
+		OutDir:         out,
+		Package:        "static",
+		File:           "static",
+		Production:     true,
+		IgnorePatterns: []string{"*.tmp"},
+		WatchDelay:     50 * time.Millisecond,
+	})
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create BindFS: %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- bf.Watch(ctx) }()
+
+	recorded := filepath.Join(out, "static.go")
+
+	// waitFor waits for the generated file to hold the given text
+	waitFor := func(text string) bool {
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+			if data, err := ioutil.ReadFile(recorded); err == nil && strings.Contains(string(data), text) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// give the watcher time to start
+	time.Sleep(200 * time.Millisecond)
+
+	for _, color := range []string{"green", "yellow", "blue"} {
+		if err := ioutil.WriteFile(filepath.Join(in, "app.css"), []byte("body { color: "+color+"; }"), 0600); err != nil {
+			flux.FatalFailed(t, "Unable to write fixture: %s", err)
+		}
+	}
+
+	if !waitFor("blue") {
+		flux.FatalFailed(t, "Expected a change to record the generated file")
+	}
+
+	os.Remove(recorded)
+
+	if err := ioutil.WriteFile(filepath.Join(in, "app.js"), []byte("alert(1)"), 0600); err != nil {
+		flux.FatalFailed(t, "Unable to write fixture: %s", err)
+	}
+
+	if !waitFor("app.js") {
+		flux.FatalFailed(t, "Expected an added file to record the generated file")
+	}
+
+	os.Remove(recorded)
+
+	if err := ioutil.WriteFile(filepath.Join(in, "notes.tmp"), []byte("ignored"), 0600); err != nil {
+		flux.FatalFailed(t, "Unable to write fixture: %s", err)
+	}
+
+	time.Sleep(300 * time.Millisecond)
+
+	if _, err := os.Stat(recorded); err == nil {
+		flux.FatalFailed(t, "Expected ignored files not to record the generated file")
+	}
+
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			flux.FatalFailed(t, "Expected Watch to return the context error but got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		flux.FatalFailed(t, "Expected Watch to return once its context is done")
+	}
+
+	flux.LogPassed(t, "Successfully recorded changes while watching")
+}
@@ -0,0 +1,351 @@
+// Command assets provides a command-line front to the assets package, allowing
+// embeddable go packages to be generated without writing a custom main.
+//
+// Usage:
+//
+//	assets bind -in ./public -out ./static -package static -file static -gzip -production
+//
+// It is most useful as a go:generate directive, with the -check flag allowing
+// CI to reject bundles that were not regenerated after their sources changed:
+//
+//	//go:generate assets bind -in ./public -out ./static -package static
+//
+//	assets bind -check -in ./public -out ./static -package static
+//
+// While working on the assets, -watch keeps regenerating the package whenever
+// the files within -in change, until interrupted:
+//
+//	assets bind -watch -in ./public -out ./static -package static
+//
+// Several directories can be bundled under their own virtual prefixes instead:
+//
+//	assets bind -mount ./public=/ -mount ./node_modules/lib/dist=/vendor/lib -out ./static -package static
+//
+// With -tag, both a development and a production file are recorded and the
+// build tag picks between them, while setting ASSETS_FROM_DISK makes a tagged
+// build read the files from disk again:
+//
+//	assets bind -tag assets_embed -in ./public -out ./static -package static
+//	go build -tags assets_embed ./...
+//
+// Packages recorded with -archive read their files from a zip archive instead,
+// which append attaches to the built executable:
+//
+//	assets append -bin ./server -archive ./static/static.assets
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influx6/assets"
+	"github.com/influx6/assets/vfiles"
+)
+
+const usage = `Usage: assets <command> [flags]
+
+Commands:
+  bind    embeds a directory into a generated go package
+  append  appends the archive of an archive mode package to an executable
+
+Run 'assets <command> -h' for the flags of a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+
+	switch os.Args[1] {
+	case "bind":
+		err = bind(os.Args[2:])
+	case "append":
+		err = appendArchive(os.Args[2:])
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(os.Stdout, usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "assets: unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "assets: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// bind parses the arguments for the bind command and records the generated
+// package for the given directory.
+func bind(args []string) error {
+	var config assets.BindFSConfig
+	var ignore string
+	var check, watch bool
+	var mtime string
+	var codecs string
+	var level int
+	var shardMB int64
+	var mounts mountList
+	var excludes patternList
+	var minify bool
+	var skipExts, skipTypes string
+	var policy = assets.DefaultCompressionPolicy
+
+	flags := flag.NewFlagSet("bind", flag.ExitOnError)
+	flags.StringVar(&config.InDir, "in", "", "directory path to use as source (required unless -mount is given)")
+	flags.StringVar(&config.StripPrefix, "strip", "", "prefix removed from the paths within -in, usually -in itself")
+	flags.StringVar(&config.MountAt, "mount-at", "", "virtual directory the paths within -in are served under")
+	flags.Var(&mounts, "mount", "dir=prefix pair adding a directory under a virtual prefix, may be repeated")
+	flags.StringVar(&config.OutDir, "out", "", "directory path to save the generated file in (required)")
+	flags.StringVar(&config.Package, "package", "", "package name of the generated file (required)")
+	flags.StringVar(&config.File, "file", "", "file name of the generated file without extension (defaults to the package name)")
+	flags.BoolVar(&config.Gzipped, "gzip", false, "gzip file contents")
+	flags.StringVar(&codecs, "codecs", "gzip", "comma separated codecs (gzip, zlib, flate) tried with -gzip, the smallest output per file wins")
+	flags.IntVar(&level, "level", 0, "compression level of the codecs, zero uses their default level")
+	flags.StringVar(&skipExts, "skip-ext", strings.Join(policy.SkipExts, ","), "comma separated extensions of files never compressed")
+	flags.StringVar(&skipTypes, "skip-type", strings.Join(policy.SkipTypes, ","), "comma separated mime types or type prefixes of files never compressed")
+	flags.Int64Var(&policy.MinSize, "min-size", policy.MinSize, "size in bytes below which files are not compressed")
+	flags.Float64Var(&policy.MinRatio, "min-ratio", policy.MinRatio, "fraction of the size compression must save for it to be kept")
+	flags.BoolVar(&config.NoDecompression, "nodecompress", false, "return gzipped contents as is, only active with -gzip")
+	flags.BoolVar(&minify, "minify", false, "minify css, js and html files and compact json files before embedding them")
+	flags.BoolVar(&config.DevTransforms, "dev-transforms", false, "apply -minify to files read from disk too, so development serves what production embeds")
+	flags.BoolVar(&config.Fingerprint, "fingerprint", false, "with -production, publish files under names holding their checksum, listed by the generated Manifest")
+	flags.BoolVar(&config.Production, "production", false, "embed file contents instead of loading them from disk")
+	flags.StringVar(&config.BuildTag, "tag", "", "record a <file>_dev.go built without this build tag and a <file>_prod.go embedding the files built with it")
+	flags.StringVar(&config.Root, "root", "", "name of the generated directory collector variable (defaults to RootDirectory)")
+	flags.StringVar(&config.Prefix, "prefix", "", "prefix for the other generated names, so several bundles can share a package")
+	flags.BoolVar(&config.SharedRuntime, "shared", false, "import the shared vfiles runtime instead of copying it into the generated file")
+	flags.BoolVar(&config.Archive, "archive", false, "with -production, write the files into a <file>.assets archive read at runtime instead of embedding them")
+	flags.BoolVar(&config.Embed, "embed", false, "with -production, stage the files beside the generated file and store them with go:embed instead of go literals")
+	flags.BoolVar(&config.Manifest, "manifest", false, "write a <file>.manifest.json report of what was embedded and left out next to the generated file")
+	flags.BoolVar(&config.ShardByDir, "shard-dir", false, "register each top-level directory from its own shard file")
+	flags.Int64Var(&shardMB, "shard-mb", 0, "start a new shard file once the current one holds this many megabytes")
+	flags.StringVar(&ignore, "ignore", "", "regular expression of paths to leave out")
+	flags.Var(&excludes, "exclude", "gitignore style pattern of paths to leave out, may be repeated")
+	flags.BoolVar(&config.NoIgnoreFiles, "no-ignore-files", false, "do not honour .gitignore and .assetsignore files within the input directories")
+	flags.StringVar(&mtime, "mtime", os.Getenv("SOURCE_DATE_EPOCH"), "pin every modification time to this RFC3339 time or unix timestamp (defaults to $SOURCE_DATE_EPOCH)")
+	flags.BoolVar(&config.Lenient, "lenient", false, "report files which can not be read as skipped instead of failing")
+	flags.BoolVar(&check, "check", false, "regenerate in memory and fail if the recorded file is out of date, without writing it")
+	flags.BoolVar(&watch, "watch", false, "keep regenerating whenever the files within -in change, until interrupted")
+	flags.Parse(args)
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("bind: unexpected arguments %q", flags.Args())
+	}
+
+	config.Mounts = mounts
+	config.IgnorePatterns = excludes
+
+	if (config.InDir == "" && len(config.Mounts) == 0) || config.OutDir == "" || config.Package == "" {
+		flags.Usage()
+		return fmt.Errorf("bind: -in or -mount, -out and -package are required")
+	}
+
+	if watch && (check || config.InDir == "") {
+		return fmt.Errorf("bind: -watch needs -in and can not be used with -check")
+	}
+
+	if config.File == "" {
+		config.File = config.Package
+	}
+
+	if ignore != "" {
+		rx, err := regexp.Compile(ignore)
+		if err != nil {
+			return fmt.Errorf("bind: invalid -ignore expression: %s", err)
+		}
+
+		config.Ignore = rx
+	}
+
+	for _, name := range splitList(codecs) {
+		codec, err := newCodec(name, level)
+		if err != nil {
+			return fmt.Errorf("bind: %s", err)
+		}
+
+		config.Codecs = append(config.Codecs, codec)
+	}
+
+	if minify {
+		config.Transformers = assets.MinifyTransformers
+	}
+
+	config.ShardSize = shardMB << 20
+
+	//every file is compressed as before unless the policy is tuned, the codecs only
+	//decide how they are compressed
+	var tuned bool
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "skip-ext", "skip-type", "min-size", "min-ratio":
+			tuned = true
+		}
+	})
+
+	if tuned {
+		policy.SkipExts = splitList(skipExts)
+		policy.SkipTypes = splitList(skipTypes)
+		config.Compression = &policy
+	}
+
+	if mtime != "" {
+		mod, err := parseTime(mtime)
+		if err != nil {
+			return fmt.Errorf("bind: invalid -mtime: %s", err)
+		}
+
+		config.ModTime = mod
+	}
+
+	bf, err := assets.NewBindFS(&config)
+	if err != nil {
+		return err
+	}
+
+	if check {
+		if err := bf.Check(); err != nil {
+			if err == assets.ErrStaleBundle {
+				return fmt.Errorf("bind: %s is out of date, rerun assets bind", filepath.Join(config.OutDir, config.File+"*.go"))
+			}
+			return err
+		}
+		return nil
+	}
+
+	if watch {
+		//failures are reported without exiting, as the next change may well fix them
+		if err := bf.Record(); err != nil {
+			log.Printf("assets: %s", err)
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		if err := bf.Watch(ctx); err != nil && err != context.Canceled {
+			return err
+		}
+		return nil
+	}
+
+	return bf.Record()
+}
+
+// appendArchive parses the arguments for the append command and appends the
+// archive to the executable, replacing any archive appended before.
+func appendArchive(args []string) error {
+	var bin, archive string
+
+	flags := flag.NewFlagSet("append", flag.ExitOnError)
+	flags.StringVar(&bin, "bin", "", "path of the executable to append the archive to (required)")
+	flags.StringVar(&archive, "archive", "", "path of the archive recorded by assets bind -archive (required)")
+	flags.Parse(args)
+
+	if flags.NArg() > 0 {
+		return fmt.Errorf("append: unexpected arguments %q", flags.Args())
+	}
+
+	if bin == "" || archive == "" {
+		flags.Usage()
+		return fmt.Errorf("append: -bin and -archive are required")
+	}
+
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	return vfiles.AppendArchive(bin, file)
+}
+
+// mountList collects the mounts given by repeated -mount flags.
+type mountList []assets.Mount
+
+// String returns the mounts as given on the command line.
+func (m *mountList) String() string {
+	var pairs []string
+	for _, mount := range *m {
+		pairs = append(pairs, mount.Dir+"="+mount.Prefix)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+// Set adds a dir=prefix pair, a directory alone is mounted at the root.
+func (m *mountList) Set(value string) error {
+	dir, prefix := value, ""
+	if index := strings.Index(value, "="); index >= 0 {
+		dir, prefix = value[:index], value[index+1:]
+	}
+
+	if dir == "" {
+		return fmt.Errorf("missing directory in %q", value)
+	}
+
+	*m = append(*m, assets.Mount{Dir: dir, Prefix: prefix})
+	return nil
+}
+
+// patternList collects the patterns given by repeated flags.
+type patternList []string
+
+// String returns the patterns as a comma separated list.
+func (p *patternList) String() string {
+	return strings.Join(*p, ",")
+}
+
+// Set adds a pattern.
+func (p *patternList) Set(value string) error {
+	*p = append(*p, value)
+	return nil
+}
+
+// newCodec returns the stdlib backed codec with the given name.
+func newCodec(name string, level int) (vfiles.Codec, error) {
+	switch name {
+	case "gzip":
+		return vfiles.GzipCodec{Level: level}, nil
+	case "zlib":
+		return vfiles.ZlibCodec{Level: level}, nil
+	case "flate":
+		return vfiles.FlateCodec{Level: level}, nil
+	}
+
+	return nil, fmt.Errorf("unknown codec %q", name)
+}
+
+// splitList returns the non-empty items of a comma separated list.
+func splitList(list string) []string {
+	var items []string
+
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// parseTime parses either a RFC3339 time or a unix timestamp in seconds.
+func parseTime(value string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
@@ -0,0 +1,85 @@
+package assets
+
+import (
+	"mime"
+	"path/filepath"
+	"strings"
+)
+
+// CompressionPolicy decides which files BindFS compresses when Gzipped is set,
+// files it turns down are embedded as they are.
+type CompressionPolicy struct {
+	SkipExts  []string // extensions (e.g ".png") of files never compressed
+	SkipTypes []string // mime types or type prefixes (e.g "video/") of files never compressed
+	MinSize   int64    // files smaller than this in bytes are never compressed
+	MinRatio  float64  // fraction of the original size compression must save for it to be kept (e.g 0.1)
+}
+
+// DefaultCompressionPolicy leaves out formats which are compressed already,
+// tiny files and files compression barely shrinks.
+var DefaultCompressionPolicy = CompressionPolicy{
+	SkipExts: []string{
+		".png", ".jpg", ".jpeg", ".gif", ".webp", ".ico",
+		".woff", ".woff2",
+		".zip", ".gz", ".tgz", ".bz2", ".xz", ".7z", ".br",
+		".mp3", ".mp4", ".ogg", ".webm",
+		".pdf",
+	},
+	SkipTypes: []string{"audio/", "video/"},
+	MinSize:   256,
+	MinRatio:  0.05,
+}
+
+// Allows returns true if a file at the path with the given size should be
+// compressed at all.
+func (c *CompressionPolicy) Allows(path string, size int64) bool {
+	if size < c.MinSize {
+		return false
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == "" {
+		return true
+	}
+
+	for _, skip := range c.SkipExts {
+		if strings.ToLower(skip) == ext {
+			return false
+		}
+	}
+
+	if len(c.SkipTypes) == 0 {
+		return true
+	}
+
+	mtype := mime.TypeByExtension(ext)
+	if mtype == "" {
+		return true
+	}
+
+	if media, _, err := mime.ParseMediaType(mtype); err == nil {
+		mtype = media
+	}
+
+	for _, skip := range c.SkipTypes {
+		if mtype == skip || (strings.HasSuffix(skip, "/") && strings.HasPrefix(mtype, skip)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Worth returns true if compressing original bytes into compressed bytes saves
+// enough to keep the compressed output.
+func (c *CompressionPolicy) Worth(original, compressed int) bool {
+	if compressed >= original {
+		return false
+	}
+
+	if original == 0 {
+		return false
+	}
+
+	return float64(original-compressed)/float64(original) >= c.MinRatio
+}
@@ -0,0 +1,261 @@
+package assets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// encodedChunk holds file contents as they get embedded in production mode,
+// shared by every file with the same contents and compression settings.
+type encodedChunk struct {
+	size     int64
+	checksum string
+	codec    string
+	stored   []byte
+
+	literalOnce sync.Once
+	literal     string
+
+	stagedOnce sync.Once
+	staged     string
+}
+
+// Literal returns the stored data as a go string expression.
+func (c *encodedChunk) Literal() string {
+	c.literalOnce.Do(func() {
+		c.literal = literal(c.stored)
+	})
+	return c.literal
+}
+
+// Staged returns the name the stored data is staged under for embedding.
+func (c *encodedChunk) Staged() string {
+	c.stagedOnce.Do(func() {
+		c.staged = fmt.Sprintf("%x", sha256.Sum256(c.stored))
+	})
+	return c.staged
+}
+
+// encodedFile holds the outcome of encoding a single file on disk.
+type encodedFile struct {
+	info       os.FileInfo
+	chunk      *encodedChunk
+	transforms []ReportTransform // what the transformers did to the contents
+	err        error             // the file could not be read
+}
+
+// fileStamp identifies the contents of a file on disk without reading it.
+type fileStamp struct {
+	size int64
+	mod  time.Time
+}
+
+// stampedChunk records the chunk a file was encoded into along with the stamp
+// it had at the time and what the transformers did to it.
+type stampedChunk struct {
+	stamp      fileStamp
+	key        string
+	transforms []ReportTransform
+}
+
+// encodeCache keeps the chunks of a render, files are matched to them by their
+// path, size and modification time or else by the digest of their contents.
+type encodeCache struct {
+	lock   sync.Mutex
+	files  map[string]stampedChunk
+	chunks map[string]*encodedChunk
+}
+
+// newEncodeCache returns an empty encodeCache.
+func newEncodeCache() *encodeCache {
+	return &encodeCache{
+		files:  make(map[string]stampedChunk),
+		chunks: make(map[string]*encodedChunk),
+	}
+}
+
+// keep records the chunk a file at the path was encoded into.
+func (c *encodeCache) keep(path string, stamp fileStamp, key string, chunk *encodedChunk, transforms []ReportTransform) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.files[path] = stampedChunk{stamp: stamp, key: key, transforms: transforms}
+	c.chunks[key] = chunk
+}
+
+// encodeAll reads and encodes the files at the given paths, mapped to their
+// virtual paths, across a pool of workers, reusing the chunks of the previous
+// render for files which did not change. Only the chunks used by this render
+// are kept for the next one.
+func (bfs *BindFS) encodeAll(paths map[string]string, embedded bool) (map[string]*encodedFile, error) {
+	bfs.cacheLock.Lock()
+	defer bfs.cacheLock.Unlock()
+
+	workers := bfs.config.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	previous, next := bfs.cache, newEncodeCache()
+	if previous == nil {
+		previous = newEncodeCache()
+	}
+
+	settings := bfs.settings()
+	results := make(map[string]*encodedFile, len(paths))
+	jobs := make(chan string)
+
+	var lock sync.Mutex
+	var failure error
+	var wg sync.WaitGroup
+
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for path := range jobs {
+				encoded, err := bfs.encodeFile(path, paths[path], settings, previous, next, nil)
+
+				// warm up what the emitting pass asks for while still in parallel
+				if err == nil && encoded.chunk != nil {
+					if embedded {
+						encoded.chunk.Staged()
+					} else {
+						encoded.chunk.Literal()
+					}
+				}
+
+				lock.Lock()
+				results[path] = encoded
+				if err != nil && failure == nil {
+					failure = err
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+
+	// stylesheets are rewritten to the fingerprinted names of the files they
+	// refer to, so they are only encoded once those are known
+	var sheets []string
+
+	for path, virtual := range paths {
+		if bfs.config.Fingerprint && stylesheet(virtual) {
+			sheets = append(sheets, path)
+			continue
+		}
+
+		jobs <- path
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	if failure != nil {
+		return nil, failure
+	}
+
+	if err := bfs.encodeSheets(sheets, paths, results, settings, previous, next); err != nil {
+		return nil, err
+	}
+
+	bfs.cache = next
+	return results, nil
+}
+
+// encodeFile returns the file at the path encoded for embedding, taking its
+// chunk from the previous cache when the file is unchanged or its contents are
+// known already. Files which can not be read are reported on the returned
+// encodedFile, while the error reports failures to transform, rewrite or
+// compress. Files given a rewrite depend on other files and are always read,
+// as are files going through a transformer function without a Version.
+func (bfs *BindFS) encodeFile(path, virtual, settings string, previous, next *encodeCache, rewrite func([]byte) ([]byte, error)) (*encodedFile, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return &encodedFile{err: err}, nil
+	}
+
+	stamp := fileStamp{size: info.Size(), mod: info.ModTime()}
+
+	// an unchanged file is not even read again, unless a function it goes through may have changed
+	if known, ok := previous.files[path]; ok && rewrite == nil && !bfs.unversioned(virtual) && known.stamp == stamp && strings.HasPrefix(known.key, settings+"|") {
+		if chunk, ok := previous.chunks[known.key]; ok {
+			next.keep(path, stamp, known.key, chunk, known.transforms)
+			return &encodedFile{info: info, chunk: chunk, transforms: known.transforms}, nil
+		}
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return &encodedFile{info: info, err: err}, nil
+	}
+
+	// the transformed contents are what gets hashed, compressed and embedded
+	content, transforms, err := bfs.transform(virtual, content)
+	if err != nil {
+		return nil, err
+	}
+
+	if rewrite != nil {
+		if content, err = rewrite(content); err != nil {
+			return nil, err
+		}
+	}
+
+	hash := sha256.Sum256(content)
+	compress := bfs.compressible(path, int64(len(content)))
+	key := fmt.Sprintf("%s|%x|%t", settings, hash, compress)
+
+	// the contents were seen before, whether at another path or at another time
+	if chunk, ok := previous.chunks[key]; ok {
+		next.keep(path, stamp, key, chunk, transforms)
+		return &encodedFile{info: info, chunk: chunk, transforms: transforms}, nil
+	}
+
+	chunk := &encodedChunk{
+		size:     int64(len(content)),
+		checksum: hex.EncodeToString(hash[:]),
+		stored:   content,
+	}
+
+	if compress {
+		compressed, name, err := bfs.compress(content)
+		if err != nil {
+			return nil, fmt.Errorf("---> BindFS: failed to compress %s -> %s", path, err)
+		}
+
+		// keep the compressed data only if it saves enough for the policy
+		if bfs.config.Compression == nil || bfs.config.Compression.Worth(len(content), len(compressed)) {
+			chunk.stored, chunk.codec = compressed, name
+		}
+	}
+
+	next.keep(path, stamp, key, chunk, transforms)
+	return &encodedFile{info: info, chunk: chunk, transforms: transforms}, nil
+}
+
+// settings describes the configuration chunks are encoded with, so changing
+// it never reuses chunks encoded before.
+func (bfs *BindFS) settings() string {
+	var policy CompressionPolicy
+	if bfs.config.Compression != nil {
+		policy = *bfs.config.Compression
+	}
+
+	// transformers are told apart by their names, versions and what they match
+	var transforms []string
+	for _, transformer := range bfs.config.Transformers {
+		transforms = append(transforms, transformer.Name+"@"+transformer.Version+":"+strings.Join(transformer.Match, ","))
+	}
+
+	return fmt.Sprintf("%t|%#v|%#v|%q|%t", bfs.config.Gzipped, bfs.codecs(), policy, transforms, bfs.config.Fingerprint)
+}
@@ -0,0 +1,157 @@
+package assets
+
+import (
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// fingerprinted returns the virtual path with the start of the checksum of the
+// contents placed before its extension, so css/app.css becomes
+// css/app.3f2a1b9c.css.
+func fingerprinted(virtual, checksum string) string {
+	ext := path.Ext(virtual)
+	name := strings.TrimSuffix(virtual, ext)
+
+	// dot files have no extension to place the fingerprint before
+	if name == "" || strings.HasSuffix(name, "/") {
+		name, ext = virtual, ""
+	}
+
+	return name + "." + checksum[:8] + ext
+}
+
+// rooted returns the virtual path rooted at /, as the manifest lists it.
+func rooted(virtual string) string {
+	return path.Join("/", virtual)
+}
+
+// baseName returns the last element of the virtual path.
+func baseName(virtual string) string {
+	return path.Base(virtual)
+}
+
+// stylesheet returns true if the virtual path names a css file, whose url
+// references are rewritten to fingerprinted names.
+func stylesheet(virtual string) bool {
+	return strings.EqualFold(path.Ext(virtual), ".css")
+}
+
+// stylesheetRef matches the url() and @import references of stylesheets,
+// capturing the reference with its quotes.
+var stylesheetRef = regexp.MustCompile(`url\(\s*("[^"]*"|'[^']*'|[^'"()\s]+)\s*\)|@import\s+("[^"]*"|'[^']*')`)
+
+// rewriteRefs replaces the references of the stylesheet at the virtual path
+// which resolve gives a published path for. References to other hosts, data
+// and fragments are left as they are, as are the queries and fragments of the
+// references rewritten.
+func rewriteRefs(virtual string, content []byte, resolve func(target string) (string, bool)) []byte {
+	return stylesheetRef.ReplaceAllFunc(content, func(match []byte) []byte {
+		groups := stylesheetRef.FindSubmatchIndex(match)
+
+		start, end := groups[2], groups[3]
+		if start < 0 {
+			start, end = groups[4], groups[5]
+		}
+
+		ref := string(match[start:end])
+		if quote := ref[0]; quote == '"' || quote == '\'' {
+			ref, start, end = ref[1:len(ref)-1], start+1, end-1
+		}
+
+		if ref == "" || strings.Contains(ref, ":") || strings.HasPrefix(ref, "//") || strings.HasPrefix(ref, "#") {
+			return match
+		}
+
+		target := ref
+		if index := strings.IndexAny(target, "?#"); index >= 0 {
+			target = target[:index]
+		}
+
+		resolved := path.Join("/", target)
+		if !strings.HasPrefix(target, "/") {
+			resolved = path.Join("/", path.Dir(virtual), target)
+		}
+
+		published, ok := resolve(resolved)
+		if !ok {
+			return match
+		}
+
+		// only the name changes, keeping the reference relative if it was
+		rewritten := strings.TrimSuffix(target, path.Base(target)) + path.Base(published) + ref[len(target):]
+
+		out := append([]byte{}, match[:start]...)
+		out = append(out, rewritten...)
+		return append(out, match[end:]...)
+	})
+}
+
+// encodeSheets encodes the stylesheets at the given paths into the results
+// after the files they refer to, rewriting their references to the names the
+// files are published under. Stylesheets referring to each other in a cycle
+// see the references closing it left as they are.
+func (bfs *BindFS) encodeSheets(sheets []string, paths map[string]string, results map[string]*encodedFile, settings string, previous, next *encodeCache) error {
+	if len(sheets) == 0 {
+		return nil
+	}
+
+	published := make(map[string]string)
+	for real, encoded := range results {
+		if encoded.chunk != nil {
+			virtual := rooted(paths[real])
+			published[virtual] = fingerprinted(virtual, encoded.chunk.checksum)
+		}
+	}
+
+	sort.Strings(sheets)
+
+	pending := make(map[string]string)
+	for _, real := range sheets {
+		pending[rooted(paths[real])] = real
+	}
+
+	var encode func(real string) error
+
+	encode = func(real string) error {
+		virtual := rooted(paths[real])
+		delete(pending, virtual)
+
+		var failure error
+
+		encoded, err := bfs.encodeFile(real, paths[real], settings, previous, next, func(content []byte) ([]byte, error) {
+			return rewriteRefs(virtual, content, func(target string) (string, bool) {
+				if dependency, ok := pending[target]; ok && failure == nil {
+					failure = encode(dependency)
+				}
+
+				name, ok := published[target]
+				return name, ok
+			}), failure
+		})
+
+		if err != nil {
+			return err
+		}
+
+		results[real] = encoded
+		if encoded.chunk != nil {
+			published[virtual] = fingerprinted(virtual, encoded.chunk.checksum)
+		}
+
+		return nil
+	}
+
+	for _, real := range sheets {
+		if _, ok := pending[rooted(paths[real])]; !ok {
+			continue
+		}
+
+		if err := encode(real); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -6,34 +6,98 @@ const (
 	packageDetails = `//Auto-generated from github.com/influx6/assets
 // DO NOT CHANGE
 
-// Package %s provides an auto-generated static embeding of data files within the specific directory %s
+%s// Package %s provides an auto-generated static embeding of data files within the specific directory %s
 package %s
 
   `
 
+	shardDetails = `//Auto-generated from github.com/influx6/assets
+// DO NOT CHANGE
+
+%spackage %s
+
+`
+
+	shardImport = `
+import (
+	"os"
+	"time"
+)
+
+`
+
+	sharedRootImport = `
+import (
+	"github.com/influx6/assets/vfiles"
+)
+
+`
+
+	sharedImport = `
+import (
+	"os"
+	"time"
+
+	"github.com/influx6/assets/vfiles"
+)
+
+`
+
 	rootDir = `
-// RootDirectory defines a directory root for these virtual files
-var RootDirectory = NewDirCollector()
+// {{ root }} defines a directory root for these virtual files
+var {{ root }} = {{ vfiles }}NewDirCollector()
+
+`
+
+	buildConstraint = `//go:build %s
 
 `
 
-	rootInit = `
+	fileInit = `
 func init(){
+	// files on disk are found relative to the location of this file, or below $ASSETS_BASE_DIR
+	var base = {{ vfiles }}ResolveBase({{ vfiles }}CallerDir(), {{ moduleDir }})
 %s
 }
 
+`
+
+	archiveLoad = `
+// {{ ArchiveError }} holds the error met mounting the archive of these virtual files,
+// which is read from %q beside the executable or from the end of the executable
+var {{ ArchiveError }} = {{ vfiles }}LoadArchive({{ root }}, %[1]q, {{ vfiles }}ArchiveOptions{
+	// files on disk are found relative to the location of this file, or below $ASSETS_BASE_DIR
+	BaseDir:      {{ vfiles }}ResolveBase({{ vfiles }}CallerDir(), {{ moduleDir }}),
+	NoDecompress: %[2]t,
+})
+
+`
+
+	embedImport = `
+import "embed"
+`
+
+	embedDirective = `//go:embed %s
+`
+
+	embedFiles = `
+// {{ embeddedFiles }} holds the staged data of these virtual files
+%svar {{ embeddedFiles }} embed.FS
+
 `
 
 	subRegister = `
-	dir.AddDirectory(%q,func() *VDir{
-		return RootDirectory.Get(%q)
+	dir.AddDirectory(%q,func() *{{ vfiles }}VDir{
+		return {{ root }}.Get(%q)
 	})
 
 `
 
 	dirRegister = `
-  RootDirectory.Set(%q,func() *VDir{
-    var dir = NewVDir(%q,%q,%q,%t)
+  {{ root }}.Set(%q,func() *{{ vfiles }}VDir{
+    var dir = {{ vfiles }}NewVDir(%q,%q,%s,%t)
+    dir.Mod = time.Unix(%d,%d)
+    dir.FileMode = os.FileMode(%#o)
 
     // register the sub-directories
     {{ subs }}
@@ -45,60 +109,70 @@ func init(){
   }())
 `
 
-	debugFile = `
-		dir.AddFile(NewVFile(%q,%q,%q,%d,%t,%t,%s))
-	`
-
-	prodRead = `func(v *VFile) ([]byte,error) {
-	    return readData(v,[]byte(%s))
-	  }`
+	mountRegister = `
+  {{ root }}.Set(%q,func() *{{ vfiles }}VDir{
+    var dir = {{ vfiles }}NewVDir(%q,"",%s,%t)
+    dir.Mod = time.Unix(%d,%d)
+    dir.FileMode = os.FileMode(0755)
 
-	comfileRead = `func(v *VFile) ([]byte, error) {
-			fo, err := os.Open(v.RealPath())
-			if err != nil {
-				return nil, fmt.Errorf("---> assets.readFile: Error reading file: %s at %s: %s\n", v.Name(), v.RealPath(), err)
-			}
+    // register the mounted sub-directories
+    {{ subs }}
 
-			defer fo.Close()
+    return dir
+  }())
+`
 
-			var buf bytes.Buffer
-			gz := gzip.NewWriter(&buf)
+	dirExtend = `
+  {
+    var dir = {{ root }}.Get(%q)
 
-			_, err = io.Copy(gz, fo)
-			gz.Close()
+    // register the files left over from the previous shard
+    {{ files }}
+  }
+`
 
-			if err != nil {
-				return nil, fmt.Errorf("---> assets.readFile.gzip: Error gzipping file: %s at %s: %s\n", v.Name(), v.RealPath(), err)
-			}
+	debugFile = `
+		{
+			var file = {{ vfiles }}NewVFile(%s,%q,%q,%d,%t,%t,%s)
+			file.Checksum = %q
+			file.Codec = %q
+			file.Mod = time.Unix(%d,%d)
+			file.FileMode = os.FileMode(%#o)
+%s			dir.AddFile(file)
+		}
+	`
 
-			return buf.Bytes(), nil
-		}`
+	transformsAssign = `			file.Transforms = []string{%s}
+`
 
-	fileRead = `func(v *VFile) ([]byte, error) {
-			fo, err := os.Open(v.RealPath())
-			if err != nil {
-				return nil, fmt.Errorf("---> assets.readFile: Error reading file: %s at %s: %s\n", v.Name(), v.RealPath(), err)
-			}
+	liveAssign = `			file.Live = true
+`
 
-			defer fo.Close()
+	aliasAdd = `			dir.AddAlias(%q,%q)
+`
 
-			var buf bytes.Buffer
+	shadowAssign = `			file.ShadowName = %q
+`
 
-			_, err = io.Copy(&buf,fo)
-			if err != nil && err != io.EOF {
-				return nil, err
-			}
+	manifestVar = `
+// {{ Manifest }} maps the logical paths of the fingerprinted files to the paths they are published under
+var {{ Manifest }} = {{ vfiles }}AssetManifest{
+%s}
 
-			return buf.Bytes(), nil
-		}`
+`
 
-	comFunc = `
-func readData(v *VFile,data []byte)([]byte,error){
-	if %t {
-		return readVData(v,data)
-	}
-	
-	return readEData(v,data)
-}
+	manifestEntry = `	%q: %q,
 `
+
+	prodRead = `func(v *{{ vfiles }}VFile) ([]byte,error) {
+	    return {{ vfiles }}ReadData(v,[]byte(%s))
+	  }`
+
+	embedRead = `func(v *{{ vfiles }}VFile) ([]byte,error) {
+	    return {{ vfiles }}ReadEmbed(v,{{ embeddedFiles }},%q)
+	  }`
+
+	comfileRead = `{{ vfiles }}ReadCompressedFile`
+
+	fileRead = `{{ vfiles }}ReadFile`
 )
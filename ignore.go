@@ -0,0 +1,396 @@
+package assets
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// IgnoreFiles names the files ignore patterns are read from within every
+// directory of a tree, later names taking precedence over earlier ones.
+var IgnoreFiles = []string{".gitignore", ".assetsignore"}
+
+// DefaultIgnorePatterns are applied ahead of any other patterns, leaving out
+// version control data and the ignore files themselves.
+var DefaultIgnorePatterns = []string{".git/", ".gitignore", ".assetsignore"}
+
+// ProjectMarkers name the files marking the root of a project, up to which
+// the IgnoreFiles of the directories above the root of LoadIgnoreRules are
+// read.
+var ProjectMarkers = []string{"go.mod", ".git"}
+
+// ignorePattern is a single gitignore style pattern.
+type ignorePattern struct {
+	above    []string // path from the directory of an ignore file found above the root of the rules down to it
+	base     []string // directory the pattern applies within, relative to the root of the rules
+	segments []string // slash separated parts of the pattern, where ** matches any number of directories
+	negate   bool     // the pattern brings back paths a previous pattern left out
+	dirOnly  bool     // the pattern only matches directories
+}
+
+// parseIgnorePattern parses a line of an ignore file found in the base
+// directory, returning false for blank lines, comments and invalid patterns.
+func parseIgnorePattern(base, line string) (ignorePattern, bool) {
+	pattern := ignorePattern{}
+	if base != "" && base != "." {
+		pattern.base = strings.Split(base, "/")
+	}
+
+	line = strings.TrimSuffix(line, "\r")
+
+	// trailing spaces are dropped unless escaped
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern, false
+	}
+
+	switch {
+	case strings.HasPrefix(line, "!"):
+		pattern.negate, line = true, line[1:]
+	case strings.HasPrefix(line, `\!`), strings.HasPrefix(line, `\#`):
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly, line = true, strings.TrimRight(line, "/")
+	}
+
+	if line == "" {
+		return pattern, false
+	}
+
+	// a pattern without a slash matches a name at any depth, otherwise it is
+	// anchored to the directory it was found in.
+	anchored := strings.Contains(line, "/")
+	pattern.segments = strings.Split(strings.TrimPrefix(line, "/"), "/")
+
+	if !anchored {
+		pattern.segments = append([]string{"**"}, pattern.segments...)
+	}
+
+	for _, segment := range pattern.segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return pattern, false
+		}
+	}
+
+	return pattern, true
+}
+
+// matches returns true if the pattern matches the path given by its parts.
+func (p ignorePattern) matches(parts []string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+
+	if len(p.above) > 0 {
+		parts = append(append([]string{}, p.above...), parts...)
+	}
+
+	if len(parts) <= len(p.base) {
+		return false
+	}
+
+	for index, name := range p.base {
+		if parts[index] != name {
+			return false
+		}
+	}
+
+	return matchSegments(p.segments, parts[len(p.base):])
+}
+
+// matchSegments matches path parts against pattern segments, where a ** segment
+// matches any number of parts and a trailing one everything below.
+func matchSegments(segments, parts []string) bool {
+	for len(segments) > 0 {
+		if segments[0] == "**" {
+			rest := segments[1:]
+			if len(rest) == 0 {
+				return len(parts) > 0
+			}
+
+			for index := range parts {
+				if matchSegments(rest, parts[index:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(parts) == 0 {
+			return false
+		}
+
+		if ok, _ := path.Match(segments[0], parts[0]); !ok {
+			return false
+		}
+
+		segments, parts = segments[1:], parts[1:]
+	}
+
+	return len(parts) == 0
+}
+
+// IgnoreRules decides which paths within a root directory are left out using
+// gitignore semantics: globs, ** across directories, ! negation and patterns
+// ending in / which only match directories. The last matching pattern wins and
+// nothing within an ignored directory can be brought back.
+type IgnoreRules struct {
+	root  string
+	abs   string
+	files bool
+
+	lock     sync.RWMutex
+	defaults []ignorePattern
+	given    []ignorePattern
+	patterns []ignorePattern
+}
+
+// NewIgnoreRules returns rules for paths within root from the default patterns
+// followed by the given ones.
+func NewIgnoreRules(root string, patterns ...string) *IgnoreRules {
+	abs, _ := filepath.Abs(root)
+
+	rules := IgnoreRules{root: root, abs: abs}
+
+	for _, line := range DefaultIgnorePatterns {
+		if pattern, ok := parseIgnorePattern("", line); ok {
+			rules.defaults = append(rules.defaults, pattern)
+		}
+	}
+
+	for _, line := range patterns {
+		if pattern, ok := parseIgnorePattern("", line); ok {
+			rules.given = append(rules.given, pattern)
+		}
+	}
+
+	rules.patterns = append(append([]ignorePattern{}, rules.defaults...), rules.given...)
+	return &rules
+}
+
+// LoadIgnoreRules returns rules like NewIgnoreRules which also honour the
+// IgnoreFiles found within root, each applying to its own directory, and the
+// ones of the directories above it up to the root of its project, named by
+// ProjectMarkers. Patterns from deeper directories take precedence over the
+// ones above them, while the given patterns take precedence over all of them,
+// as command line excludes do with git.
+func LoadIgnoreRules(root string, patterns ...string) (*IgnoreRules, error) {
+	rules := NewIgnoreRules(root, patterns...)
+	rules.files = true
+
+	if err := rules.Reload(); err != nil {
+		return nil, err
+	}
+
+	return rules, nil
+}
+
+// Reload reads the ignore files of the rules again, skipping directories the
+// rules already leave out. Rules created with NewIgnoreRules are left as is.
+func (r *IgnoreRules) Reload() error {
+	if !r.files {
+		return nil
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.patterns = append([]ignorePattern{}, r.defaults...)
+
+	if err := r.readAbove(); err != nil {
+		return err
+	}
+
+	err := filepath.Walk(r.root, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info == nil || !info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(r.root, file)
+		if err != nil {
+			return nil
+		}
+
+		rel = filepath.ToSlash(rel)
+
+		// the given patterns apply on top of the files read so far
+		current := append(r.patterns[:len(r.patterns):len(r.patterns)], r.given...)
+		if rel != "." && matchPatterns(current, strings.Split(rel, "/"), true) {
+			return filepath.SkipDir
+		}
+
+		for _, name := range IgnoreFiles {
+			if err := r.read(nil, rel, filepath.Join(file, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	r.patterns = append(r.patterns, r.given...)
+	return err
+}
+
+// readAbove adds the patterns of the ignore files found in the directories
+// above the root, up to the root of its project, outermost first. Nothing is
+// read when the root is not within a project.
+func (r *IgnoreRules) readAbove() error {
+	var dirs []string
+
+	for dir := filepath.Dir(r.abs); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+
+		if projectRoot(dir) {
+			break
+		}
+
+		if filepath.Dir(dir) == dir {
+			return nil
+		}
+	}
+
+	for index := len(dirs) - 1; index >= 0; index-- {
+		rel, err := filepath.Rel(dirs[index], r.abs)
+		if err != nil {
+			return nil
+		}
+
+		above := strings.Split(filepath.ToSlash(rel), "/")
+
+		for _, name := range IgnoreFiles {
+			if err := r.read(above, "", filepath.Join(dirs[index], name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// projectRoot returns true if the directory holds one of the ProjectMarkers.
+func projectRoot(dir string) bool {
+	for _, name := range ProjectMarkers {
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// read adds the patterns of the ignore file found in the base directory, or
+// above the root when above holds the path from its directory down to the root.
+func (r *IgnoreRules) read(above []string, base, file string) error {
+	fo, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+
+	defer fo.Close()
+
+	scanner := bufio.NewScanner(fo)
+	for scanner.Scan() {
+		if pattern, ok := parseIgnorePattern(base, scanner.Text()); ok {
+			pattern.above = above
+			r.patterns = append(r.patterns, pattern)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// Match returns true if the slash separated path, relative to the root of the
+// rules, is left out. Paths outside of the root never are.
+func (r *IgnoreRules) Match(rel string, isDir bool) bool {
+	rel = path.Clean("/" + filepath.ToSlash(rel))
+	if rel == "/" {
+		return false
+	}
+
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	parts := strings.Split(rel[1:], "/")
+
+	// a path within an ignored directory is ignored whatever its own patterns say
+	for index := 1; index < len(parts); index++ {
+		if r.match(parts[:index], true) {
+			return true
+		}
+	}
+
+	return r.match(parts, isDir)
+}
+
+// match returns true if the last pattern matching the path is not a negation.
+func (r *IgnoreRules) match(parts []string, isDir bool) bool {
+	return matchPatterns(r.patterns, parts, isDir)
+}
+
+// matchPatterns returns true if the last of the patterns matching the path is
+// not a negation.
+func matchPatterns(patterns []ignorePattern, parts []string, isDir bool) bool {
+	var ignored bool
+
+	for _, pattern := range patterns {
+		if pattern.matches(parts, isDir) {
+			ignored = !pattern.negate
+		}
+	}
+
+	return ignored
+}
+
+// Ignored returns true if the file, given relative to the working directory or
+// as an absolute path, is left out.
+func (r *IgnoreRules) Ignored(file string, isDir bool) bool {
+	root := r.root
+	if filepath.IsAbs(file) != filepath.IsAbs(root) {
+		file, _ = filepath.Abs(file)
+		root = r.abs
+	}
+
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		return false
+	}
+
+	rel = filepath.ToSlash(rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return false
+	}
+
+	return r.Match(rel, isDir)
+}
+
+// Validator returns a PathValidator turning down the paths the rules leave out
+// before handing the others to next, if any.
+func (r *IgnoreRules) Validator(next PathValidator) PathValidator {
+	return func(file string, in os.FileInfo) bool {
+		if r.Ignored(file, in != nil && in.IsDir()) {
+			return false
+		}
+
+		return next == nil || next(file, in)
+	}
+}
+
+// ignoreRules returns the rules for paths within root, honouring its ignore
+// files unless noFiles is set.
+func ignoreRules(root string, patterns []string, noFiles bool) (*IgnoreRules, error) {
+	if noFiles {
+		return NewIgnoreRules(root, patterns...), nil
+	}
+
+	return LoadIgnoreRules(root, patterns...)
+}
@@ -0,0 +1,208 @@
+package assets
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+)
+
+// literal states, a piece of data is either written within a raw (`...`) or an
+// interpreted ("...") string literal.
+const (
+	rawLiteral = iota
+	quotedLiteral
+)
+
+// noLiteral marks a piece of data which can not be written within a literal.
+const noLiteral = -1
+
+// literal returns data as a go string expression, joining raw and interpreted
+// string literals so that the fewest source bytes are used. Text mostly ends
+// up in raw literals, where newlines and quotes cost a single byte, while
+// binary data ends up in interpreted literals, where only the bytes which are
+// not printable get escaped.
+func literal(data []byte) string {
+	if len(data) == 0 {
+		return `""`
+	}
+
+	// cost[s] holds the cost of encoding the data seen so far ending in state s,
+	// while from records the state each piece was reached from for every state.
+	var cost [2]int
+	var from [][2]int8
+	var sizes []uint8
+
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		if r == utf8.RuneError && size == 1 {
+			r = -1
+		}
+
+		pieces := [2]int{rawCost(r, size), quotedCost(r, size)}
+
+		var next [2]int
+		var steps [2]int8
+
+		for state, piece := range pieces {
+			if piece == noLiteral {
+				next[state] = noLiteral
+				continue
+			}
+
+			// starting a literal costs its quotes, joining one costs a + as well.
+			stay, other := cost[state], cost[1-state]
+			if len(sizes) == 0 {
+				stay, other = 2, noLiteral
+			}
+
+			if other != noLiteral {
+				other += 3
+			}
+
+			switch {
+			case stay != noLiteral && (other == noLiteral || stay <= other):
+				next[state], steps[state] = stay+piece, int8(state)
+			default:
+				next[state], steps[state] = other+piece, int8(1-state)
+			}
+		}
+
+		cost = next
+		from = append(from, steps)
+		sizes = append(sizes, uint8(size))
+		i += size
+	}
+
+	// walk back from the cheapest final state to find the state of every piece.
+	state := quotedLiteral
+	if cost[rawLiteral] != noLiteral && cost[rawLiteral] < cost[quotedLiteral] {
+		state = rawLiteral
+	}
+
+	states := make([]int8, len(sizes))
+	for i := len(sizes) - 1; i >= 0; i-- {
+		states[i] = int8(state)
+		state = int(from[i][state])
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data) + len(data)/4)
+
+	var offset int
+	for i, size := range sizes {
+		piece := data[offset : offset+int(size)]
+		offset += int(size)
+
+		if i == 0 || states[i] != states[i-1] {
+			if i > 0 {
+				out.WriteString(closer(states[i-1]))
+				out.WriteByte('+')
+			}
+			out.WriteString(closer(states[i]))
+		}
+
+		if states[i] == rawLiteral {
+			out.Write(piece)
+			continue
+		}
+
+		writeQuoted(&out, piece)
+	}
+
+	out.WriteString(closer(states[len(states)-1]))
+
+	return out.String()
+}
+
+// closer returns the delimiter of a literal state.
+func closer(state int8) string {
+	if state == rawLiteral {
+		return "`"
+	}
+	return `"`
+}
+
+// rawCost returns the bytes needed to write a rune of the given size within a
+// raw string literal, which can not hold backquotes, carriage returns (they get
+// discarded), byte order marks, invalid utf-8 or anything not printable.
+func rawCost(r rune, size int) int {
+	switch {
+	case r == '\n' || r == '\t':
+		return 1
+	case r < 0 || r == '`' || r == '\uFEFF':
+		return noLiteral
+	case r < utf8.RuneSelf && (r < ' ' || r == 0x7f):
+		return noLiteral
+	case r >= utf8.RuneSelf && !unicode.IsPrint(r):
+		return noLiteral
+	}
+	return size
+}
+
+// quotedCost returns the bytes needed to write a rune of the given size within
+// an interpreted string literal.
+func quotedCost(r rune, size int) int {
+	switch {
+	case r < 0:
+		return 4
+	case r == '"' || r == '\\':
+		return 2
+	case r == '\a' || r == '\b' || r == '\f' || r == '\n' || r == '\r' || r == '\t' || r == '\v':
+		return 2
+	case r < utf8.RuneSelf && (r < ' ' || r == 0x7f):
+		return 4
+	case r >= utf8.RuneSelf && (r == '\uFEFF' || !unicode.IsPrint(r)):
+		return 4 * size
+	}
+	return size
+}
+
+// writeQuoted writes a single rune, or invalid byte, as it should appear
+// within an interpreted string literal, matching quotedCost.
+func writeQuoted(out *bytes.Buffer, piece []byte) {
+	r, size := utf8.DecodeRune(piece)
+	if r == utf8.RuneError && size == 1 {
+		r = -1
+	}
+
+	switch r {
+	case '"':
+		out.WriteString(`\"`)
+		return
+	case '\\':
+		out.WriteString(`\\`)
+		return
+	case '\a':
+		out.WriteString(`\a`)
+		return
+	case '\b':
+		out.WriteString(`\b`)
+		return
+	case '\f':
+		out.WriteString(`\f`)
+		return
+	case '\n':
+		out.WriteString(`\n`)
+		return
+	case '\r':
+		out.WriteString(`\r`)
+		return
+	case '\t':
+		out.WriteString(`\t`)
+		return
+	case '\v':
+		out.WriteString(`\v`)
+		return
+	}
+
+	if quotedCost(r, size) == size {
+		out.Write(piece)
+		return
+	}
+
+	for _, b := range piece {
+		out.WriteString(`\x`)
+		out.WriteByte(lowerhex[b/16])
+		out.WriteByte(lowerhex[b%16])
+	}
+}
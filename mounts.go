@@ -0,0 +1,199 @@
+package assets
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Mount adds a directory to a BindFS bundle under a virtual prefix.
+type Mount struct {
+	Dir       string         // directory path to use as source
+	Prefix    string         // virtual path the directory is served under (e.g /static), the root when empty
+	ValidPath PathValidator  // filters allowed paths within the directory, on top of BindFSConfig.ValidPath
+	Ignore    *regexp.Regexp // paths to leave out, matched against paths relative to Dir
+}
+
+// validator returns a PathValidator applying the rules of the mount after the
+// ones given, recording the paths it turns down.
+func (m Mount) validator(valid PathValidator, skips *skipList) PathValidator {
+	return func(file string, in os.FileInfo) bool {
+		if !valid(file, in) {
+			return false
+		}
+
+		if rel, err := filepath.Rel(m.Dir, file); err == nil && m.Ignore != nil && m.Ignore.MatchString(filepath.ToSlash(rel)) {
+			skips.add(filepath.ToSlash(file), SkipIgnored)
+			return false
+		}
+
+		if m.ValidPath != nil && !m.ValidPath(file, in) {
+			skips.add(filepath.ToSlash(file), SkipRejected)
+			return false
+		}
+
+		return true
+	}
+}
+
+// mux returns a PathMux placing the paths of the mount under its prefix before
+// handing them to the given mux, if any.
+func (m Mount) mux(mux PathMux) PathMux {
+	return rewriteMux(m.Dir, m.Prefix, mux)
+}
+
+// bundleChild links a directory to one of its sub-directories.
+type bundleChild struct {
+	name string
+	path string
+}
+
+// bundleDir is a directory of the generated tree.
+type bundleDir struct {
+	path     string
+	tree     *BasicAssetTree // listing of the directory, nil for directories only holding mounts
+	root     bool
+	children []bundleChild
+}
+
+// mounted returns true when the listings are rewritten to virtual paths rather
+// than using the paths they are found at.
+func (bfs *BindFS) mounted() bool {
+	return len(bfs.config.Mounts) > 0 || bfs.config.StripPrefix != "" || bfs.config.MountAt != ""
+}
+
+// bundle returns the directories of every listing in sorted order, failing
+// when two of them produce the same virtual path. With mounts or rewritten
+// paths, directories are linked to their parents and the parents which only
+// hold mount prefixes are added, up to the root directory.
+func (bfs *BindFS) bundle() ([]*bundleDir, error) {
+	mounted := bfs.mounted()
+
+	dirs := make(map[string]*bundleDir)
+	files := make(map[string]string)
+
+	for _, listing := range bfs.listings {
+		for _, key := range listing.Listings.Keys() {
+			tree := listing.Listings.Get(key)
+			if tree == nil {
+				continue
+			}
+
+			dir := &bundleDir{path: trimParent(key), tree: tree, root: tree.root && !mounted}
+
+			if known, ok := dirs[dir.path]; ok {
+				return nil, fmt.Errorf("---> BindFS: %s and %s both map to virtual path %s", known.tree.Dir, tree.Dir, dir.path)
+			}
+
+			dirs[dir.path] = dir
+
+			for _, modded := range tree.Tree.Keys() {
+				real, virtual := tree.Tree.Get(modded), trimParent(modded)
+
+				if known, ok := files[virtual]; ok {
+					return nil, fmt.Errorf("---> BindFS: %s and %s both map to virtual path %s", known, real, virtual)
+				}
+
+				files[virtual] = real
+			}
+
+			if mounted {
+				continue
+			}
+
+			var children []string
+
+			tree.EachChild(func(child *BasicAssetTree) {
+				children = append(children, filepath.ToSlash(filepath.Clean(child.ModDir)))
+			})
+
+			sort.Strings(children)
+
+			for _, childDir := range children {
+				baseChildDir := filepath.Base(childDir)
+
+				if baseChildDir == ".." {
+					baseChildDir = "/"
+				}
+
+				// if it has a .. at the beginning, remove it.
+				dir.children = append(dir.children, bundleChild{name: baseChildDir, path: strings.TrimPrefix(childDir, "..")})
+			}
+		}
+	}
+
+	if mounted {
+		var link func(dir string)
+		link = func(dir string) {
+			if dir == "/" {
+				return
+			}
+
+			parentPath := path.Dir(dir)
+			if parentPath == "." {
+				parentPath = "/"
+			}
+
+			parent, ok := dirs[parentPath]
+			if !ok {
+				parent = &bundleDir{path: parentPath}
+				dirs[parentPath] = parent
+				link(parentPath)
+			}
+
+			for _, child := range parent.children {
+				if child.path == dir {
+					return
+				}
+			}
+
+			parent.children = append(parent.children, bundleChild{name: path.Base(dir), path: dir})
+		}
+
+		var paths []string
+		for dir := range dirs {
+			paths = append(paths, dir)
+		}
+
+		for _, dir := range paths {
+			link(dir)
+		}
+
+		if _, ok := dirs["/"]; !ok {
+			dirs["/"] = &bundleDir{path: "/"}
+		}
+
+		dirs["/"].root = true
+	}
+
+	var list []*bundleDir
+
+	for _, dir := range dirs {
+		sort.Slice(dir.children, func(i, j int) bool {
+			return dir.children[i].path < dir.children[j].path
+		})
+
+		list = append(list, dir)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].path < list[j].path
+	})
+
+	return list, nil
+}
+
+// bundleRoot returns the path of the root directory of a bundle.
+func bundleRoot(dirs []*bundleDir) string {
+	for _, dir := range dirs {
+		if dir.root {
+			return dir.path
+		}
+	}
+
+	return "."
+}
@@ -0,0 +1,194 @@
+package assets
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ManifestExt is the extension of the manifest recorded next to the generated
+// go file when BindFSConfig.Manifest is set.
+const ManifestExt = ".manifest.json"
+
+// RecordReport describes what a Record or Check call embedded and left out.
+type RecordReport struct {
+	Files   []ReportFile `json:"files"`
+	Skipped []ReportSkip `json:"skipped"`
+	Totals  ReportTotals `json:"totals"`
+}
+
+// ReportFile describes a single embedded file.
+type ReportFile struct {
+	Path       string `json:"path"`                // virtual path the file is served under
+	Published  string `json:"published,omitempty"` // fingerprinted path the file is published under, if any
+	Source     string `json:"source"`              // path of the file relative to the generated file
+	Size       int64  `json:"size"`                // size of the original contents
+	StoredSize int64  `json:"storedSize"`          // bytes stored for the file, zero in development mode
+	Codec      string `json:"codec,omitempty"`     // codec the stored bytes are compressed with
+	Checksum   string `json:"checksum,omitempty"`  // SHA-256 digest of the original contents, left out in development mode
+
+	Transforms []ReportTransform `json:"transforms,omitempty"` // transformers the contents went through, left out in development mode
+}
+
+// ReportTransform describes what a Transformer did to a file.
+type ReportTransform struct {
+	Name  string `json:"name"`
+	Saved int64  `json:"saved"` // bytes the transformer took off, negative when it added some
+}
+
+// SkipReason describes why a path was left out of the embedded files.
+type SkipReason string
+
+// reasons paths get skipped for
+const (
+	SkipIgnored   SkipReason = "ignored"    // matched BindFSConfig.Ignore or the ignore rules
+	SkipRejected  SkipReason = "rejected"   // turned down by BindFSConfig.ValidPath
+	SkipReadError SkipReason = "read error" // could not be read
+)
+
+// ReportSkip describes a path which was left out of the embedded files.
+type ReportSkip struct {
+	Path   string     `json:"path"`
+	Reason SkipReason `json:"reason"`
+	Error  string     `json:"error,omitempty"`
+}
+
+// RecordError is returned by Record and Check unless BindFSConfig.Lenient is
+// set, listing every path which could not be read along with its error.
+type RecordError struct {
+	Failures []ReportSkip
+}
+
+// Error returns the failures one per line.
+func (e *RecordError) Error() string {
+	lines := make([]string, len(e.Failures))
+	for index, failure := range e.Failures {
+		lines[index] = fmt.Sprintf("%s: %s", failure.Path, failure.Error)
+	}
+
+	return fmt.Sprintf("---> BindFS: unable to read %d paths:\n\t%s", len(e.Failures), strings.Join(lines, "\n\t"))
+}
+
+// ReportTotals sums up a RecordReport.
+type ReportTotals struct {
+	Files      int   `json:"files"`
+	Skipped    int   `json:"skipped"`
+	Size       int64 `json:"size"`
+	StoredSize int64 `json:"storedSize"`
+
+	Saved map[string]int64 `json:"saved,omitempty"` // bytes each transformer took off across the files
+}
+
+// addFile adds an embedded file to the report.
+func (r *RecordReport) addFile(file ReportFile) {
+	r.Files = append(r.Files, file)
+}
+
+// addSkip adds a path left out to the report.
+func (r *RecordReport) addSkip(path string, reason SkipReason, err error) {
+	skip := ReportSkip{Path: path, Reason: reason}
+	if err != nil {
+		skip.Error = err.Error()
+	}
+
+	r.Skipped = append(r.Skipped, skip)
+}
+
+// finish sorts the report and sums up its totals.
+func (r *RecordReport) finish() {
+	sort.SliceStable(r.Files, func(i, j int) bool {
+		return r.Files[i].Path < r.Files[j].Path
+	})
+
+	sort.SliceStable(r.Skipped, func(i, j int) bool {
+		return r.Skipped[i].Path < r.Skipped[j].Path
+	})
+
+	r.Totals = ReportTotals{Files: len(r.Files), Skipped: len(r.Skipped)}
+
+	for _, file := range r.Files {
+		r.Totals.Size += file.Size
+		r.Totals.StoredSize += file.StoredSize
+
+		for _, transform := range file.Transforms {
+			if r.Totals.Saved == nil {
+				r.Totals.Saved = make(map[string]int64)
+			}
+
+			r.Totals.Saved[transform.Name] += transform.Saved
+		}
+	}
+}
+
+// failures returns a RecordError for the paths of the report which could not
+// be read, if any.
+func (r *RecordReport) failures() error {
+	var failures []ReportSkip
+	for _, skip := range r.Skipped {
+		if skip.Reason == SkipReadError {
+			failures = append(failures, skip)
+		}
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	return &RecordError{Failures: failures}
+}
+
+// manifest returns the report as indented JSON.
+func (r *RecordReport) manifest() ([]byte, error) {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append(data, '\n'), nil
+}
+
+// skipList collects the paths a listing leaves out while it loads.
+type skipList struct {
+	lock  sync.Mutex
+	skips map[string]ReportSkip
+}
+
+// add records a path left out for the given reason.
+func (s *skipList) add(path string, reason SkipReason) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.skips == nil {
+		s.skips = make(map[string]ReportSkip)
+	}
+
+	s.skips[path] = ReportSkip{Path: path, Reason: reason}
+}
+
+// fail records a path which could not be read.
+func (s *skipList) fail(path string, err error) {
+	s.add(path, SkipReadError)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	skip := s.skips[path]
+	skip.Error = err.Error()
+	s.skips[path] = skip
+}
+
+// take returns the paths collected so far and starts over.
+func (s *skipList) take() []ReportSkip {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var skips []ReportSkip
+	for _, skip := range s.skips {
+		skips = append(skips, skip)
+	}
+
+	s.skips = nil
+	return skips
+}
@@ -0,0 +1,271 @@
+package assets
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// runtimeFiles holds the sources of the vfiles package which get copied into
+// every generated file, embedding them keeps Record independent of where this
+// package is checked out.
+//
+//go:embed vfiles/*.go
+var runtimeFiles embed.FS
+
+var runtimeOnce struct {
+	sync.Once
+	source string
+	err    error
+}
+
+// runtimeSource returns the vfiles package sources merged into a single import
+// block and body, without the package clause.
+func runtimeSource() (string, error) {
+	runtimeOnce.Do(func() {
+		runtimeOnce.source, runtimeOnce.err = mergeRuntime()
+	})
+	return runtimeOnce.source, runtimeOnce.err
+}
+
+var prefixedRuntimes struct {
+	sync.Mutex
+	sources map[string]string
+}
+
+// prefixedRuntime returns the runtime source with the prefix added to every
+// name it declares at the top level, so runtimes copied by bundles with other
+// prefixes can share a package.
+func prefixedRuntime(prefix string) (string, error) {
+	source, err := runtimeSource()
+	if err != nil || prefix == "" {
+		return source, err
+	}
+
+	prefixedRuntimes.Lock()
+	defer prefixedRuntimes.Unlock()
+
+	if source, ok := prefixedRuntimes.sources[prefix]; ok {
+		return source, nil
+	}
+
+	source, err = prefixRuntime(source, prefix)
+	if err != nil {
+		return "", err
+	}
+
+	if prefixedRuntimes.sources == nil {
+		prefixedRuntimes.sources = make(map[string]string)
+	}
+
+	prefixedRuntimes.sources[prefix] = source
+	return source, nil
+}
+
+// prefixRuntime renames every top-level declaration of the runtime source and
+// the references to them, along with the fields embedding the renamed types.
+// Fields, methods and local names are left alone.
+func prefixRuntime(source, prefix string) (string, error) {
+	const clause = "package runtime\n"
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "runtime.go", clause+source, 0)
+	if err != nil {
+		return "", fmt.Errorf("---> BindFS: unable to parse runtime -> %s", err)
+	}
+
+	declared := make(map[*ast.Object]bool)
+	types := make(map[string]bool)
+
+	for _, decl := range file.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			if decl.Recv == nil && decl.Name.Obj != nil {
+				declared[decl.Name.Obj] = true
+			}
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					declared[spec.Name.Obj] = true
+					types[spec.Name.Name] = true
+				case *ast.ValueSpec:
+					for _, name := range spec.Names {
+						if name.Obj != nil {
+							declared[name.Obj] = true
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// embedded fields are named after their type, so they get renamed along with it
+	embedded := make(map[string]bool)
+	ast.Inspect(file, func(node ast.Node) bool {
+		if fields, ok := node.(*ast.StructType); ok {
+			for _, field := range fields.Fields.List {
+				typ := field.Type
+				if star, ok := typ.(*ast.StarExpr); ok {
+					typ = star.X
+				}
+
+				if ident, ok := typ.(*ast.Ident); ok && len(field.Names) == 0 && types[ident.Name] {
+					embedded[ident.Name] = true
+				}
+			}
+		}
+		return true
+	})
+
+	// selectors and struct literal keys name fields rather than declarations
+	fields := make(map[*ast.Ident]bool)
+	var renamed []*ast.Ident
+
+	ast.Inspect(file, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.SelectorExpr:
+			fields[node.Sel] = true
+		case *ast.CompositeLit:
+			switch node.Type.(type) {
+			case *ast.MapType, *ast.ArrayType:
+			default:
+				for _, elt := range node.Elts {
+					if pair, ok := elt.(*ast.KeyValueExpr); ok {
+						if key, ok := pair.Key.(*ast.Ident); ok {
+							fields[key] = true
+						}
+					}
+				}
+			}
+		case *ast.Ident:
+			switch {
+			case fields[node]:
+				if embedded[node.Name] {
+					renamed = append(renamed, node)
+				}
+			case node.Obj != nil && declared[node.Obj] && node.Name != "init" && node.Name != "_":
+				renamed = append(renamed, node)
+			}
+		}
+		return true
+	})
+
+	sort.Slice(renamed, func(i, j int) bool {
+		return renamed[i].Pos() > renamed[j].Pos()
+	})
+
+	out := []byte(clause + source)
+	for _, ident := range renamed {
+		offset := fset.Position(ident.Pos()).Offset
+		name := []byte(prefixed(prefix, ident.Name))
+		out = append(out[:offset], append(name, out[offset+len(ident.Name):]...)...)
+	}
+
+	return string(out[len(clause):]), nil
+}
+
+// prefixed returns the name with the prefix added, keeping it exported or not.
+func prefixed(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+
+	first, size := utf8.DecodeRuneInString(prefix)
+	if initial, _ := utf8.DecodeRuneInString(name); unicode.IsUpper(initial) {
+		return string(unicode.ToUpper(first)) + prefix[size:] + name
+	}
+
+	initial, length := utf8.DecodeRuneInString(name)
+	return string(unicode.ToLower(first)) + prefix[size:] + string(unicode.ToUpper(initial)) + name[length:]
+}
+
+// mergeRuntime parses every non-test file of the embedded vfiles package,
+// collecting their imports into one block followed by the remaining contents
+// of each file.
+func mergeRuntime() (string, error) {
+	entries, err := runtimeFiles.ReadDir("vfiles")
+	if err != nil {
+		return "", err
+	}
+
+	var names []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), "_test.go") {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+
+	sort.Strings(names)
+
+	imports := make(map[string]string)
+	fset := token.NewFileSet()
+
+	var body bytes.Buffer
+
+	for _, name := range names {
+		src, err := runtimeFiles.ReadFile(path.Join("vfiles", name))
+		if err != nil {
+			return "", err
+		}
+
+		file, err := parser.ParseFile(fset, name, src, parser.ImportsOnly)
+		if err != nil {
+			return "", fmt.Errorf("---> BindFS: unable to parse runtime file %s -> %s", name, err)
+		}
+
+		// the body starts after the last import declaration or the package clause
+		// when the file imports nothing.
+		end := file.Name.End()
+		if len(file.Decls) > 0 {
+			end = file.Decls[len(file.Decls)-1].End()
+		}
+
+		for _, spec := range file.Imports {
+			ipath, _ := strconv.Unquote(spec.Path.Value)
+
+			var alias string
+			if spec.Name != nil {
+				alias = spec.Name.Name
+			}
+
+			imports[ipath] = alias
+		}
+
+		body.Write(src[fset.Position(end).Offset:])
+	}
+
+	var paths []string
+	for ipath := range imports {
+		paths = append(paths, ipath)
+	}
+
+	sort.Strings(paths)
+
+	var out bytes.Buffer
+
+	out.WriteString("import (\n")
+	for _, ipath := range paths {
+		if alias := imports[ipath]; alias != "" {
+			fmt.Fprintf(&out, "\t%s %q\n", alias, ipath)
+			continue
+		}
+		fmt.Fprintf(&out, "\t%q\n", ipath)
+	}
+	out.WriteString(")\n")
+
+	out.Write(body.Bytes())
+
+	return out.String(), nil
+}
@@ -1,6 +1,9 @@
 package assets
 
-import "sync"
+import (
+	"sort"
+	"sync"
+)
 
 // AssetTreeMap represent a map of paths and asset maps
 type AssetTreeMap map[string]*BasicAssetTree
@@ -52,6 +55,20 @@ func (t *TreeMapWriter) Each(fx func(*BasicAssetTree, string)) {
 	t.Wo.RUnlock()
 }
 
+// Keys returns the paths of the trees in the map in sorted order
+func (t *TreeMapWriter) Keys() []string {
+	t.Wo.RLock()
+	defer t.Wo.RUnlock()
+
+	keys := make([]string, 0, len(t.Tree))
+	for p := range t.Tree {
+		keys = append(keys, p)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
 // Delete removes a tree with the set string
 func (t *TreeMapWriter) Delete(c string) {
 	t.Wo.Lock()
@@ -129,6 +146,20 @@ func (t *MapWriter) Each(fx func(string, string)) {
 	t.Wo.RUnlock()
 }
 
+// Keys returns the keys of the map in sorted order
+func (t *MapWriter) Keys() []string {
+	t.Wo.RLock()
+	defer t.Wo.RUnlock()
+
+	keys := make([]string, 0, len(t.Tree))
+	for p := range t.Tree {
+		keys = append(keys, p)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
 // Delete removes a tree with the set string
 func (t *MapWriter) Delete(c string) {
 	t.Wo.Lock()
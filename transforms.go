@@ -0,0 +1,164 @@
+package assets
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/influx6/assets/vfiles"
+)
+
+// Transformer rewrites the contents of the files it matches before they are
+// hashed, compressed and embedded. The transformers of a BindFSConfig form a
+// chain, each one handed the output of the ones before it.
+type Transformer struct {
+	Name  string               // names the transformer in reports, and the vfiles transform used when Func is nil
+	Match []string             // extensions such as ".css", or globs matched against the virtual path or its base name when they hold no slash
+	Func  vfiles.TransformFunc // rewrites the contents, defaults to the transform registered with vfiles under Name
+
+	// Version tells revisions of Func apart, as functions can not be compared.
+	// Unchanged files are only encoded again when it changes, while files a
+	// Func without a Version applies to are read and transformed every time.
+	Version string
+}
+
+// MinifyTransformers minify stylesheets, scripts and html documents and
+// compact json files with the transforms built into vfiles.
+var MinifyTransformers = []Transformer{
+	{Name: "minify-css", Match: []string{".css"}},
+	{Name: "minify-js", Match: []string{".js", ".mjs"}},
+	{Name: "minify-html", Match: []string{".html", ".htm"}},
+	{Name: "compact-json", Match: []string{".json"}},
+}
+
+// matches returns true if the transformer applies to the virtual path.
+func (t Transformer) matches(virtual string) bool {
+	virtual = strings.TrimPrefix(virtual, "/")
+
+	for _, match := range t.Match {
+		if strings.HasPrefix(match, ".") && !strings.ContainsAny(match, "*?[") {
+			if strings.EqualFold(path.Ext(virtual), match) {
+				return true
+			}
+			continue
+		}
+
+		name := virtual
+		if !strings.Contains(match, "/") {
+			name = path.Base(virtual)
+		}
+
+		if ok, _ := path.Match(strings.TrimPrefix(match, "/"), name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// versioned returns true if a change of the transformer changes its settings,
+// which holds for the registered transforms and functions given a Version.
+func (t Transformer) versioned() bool {
+	return t.Func == nil || t.Version != ""
+}
+
+// transform returns the function rewriting the contents.
+func (t Transformer) transform() (vfiles.TransformFunc, error) {
+	if t.Func != nil {
+		return t.Func, nil
+	}
+
+	return vfiles.LookupTransform(t.Name)
+}
+
+// validTransformers returns an error for transformers without a name, a
+// valid match or a function to run.
+func validTransformers(transformers []Transformer) error {
+	for _, transformer := range transformers {
+		if transformer.Name == "" {
+			return fmt.Errorf("---> BindFS: Transformers need a name")
+		}
+
+		for _, match := range transformer.Match {
+			if _, err := path.Match(match, ""); err != nil {
+				return fmt.Errorf("---> BindFS: Transformer %q has an invalid match %q -> %s", transformer.Name, match, err)
+			}
+		}
+
+		if _, err := transformer.transform(); err != nil {
+			return fmt.Errorf("---> BindFS: Transformer %q has no function -> %s", transformer.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// transformers returns the names of the transformers applying to the virtual
+// path, in the order they run.
+func (bfs *BindFS) transformers(virtual string) []string {
+	var names []string
+
+	for _, transformer := range bfs.config.Transformers {
+		if transformer.matches(virtual) {
+			names = append(names, transformer.Name)
+		}
+	}
+
+	return names
+}
+
+// unversioned returns true if a transformer applying to the virtual path runs
+// a function without a Version, so the output for the path can not be known
+// without transforming it again.
+func (bfs *BindFS) unversioned(virtual string) bool {
+	for _, transformer := range bfs.config.Transformers {
+		if transformer.matches(virtual) && !transformer.versioned() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// transform returns the contents rewritten by the transformers applying to
+// the virtual path, along with the bytes each of them saved.
+func (bfs *BindFS) transform(virtual string, data []byte) ([]byte, []ReportTransform, error) {
+	var saved []ReportTransform
+
+	for _, transformer := range bfs.config.Transformers {
+		if !transformer.matches(virtual) {
+			continue
+		}
+
+		fn, err := transformer.transform()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		out, err := fn(virtual, data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("---> BindFS: failed to transform %s with %s -> %s", virtual, transformer.Name, err)
+		}
+
+		saved = append(saved, ReportTransform{Name: transformer.Name, Saved: int64(len(data) - len(out))})
+		data = out
+	}
+
+	return data, saved, nil
+}
+
+// fileTransforms returns the assignment naming the transforms a file read
+// from disk goes through, which is only written with DevTransforms.
+func (bfs *BindFS) fileTransforms(virtual string) string {
+	names := bfs.transformers(virtual)
+	if !bfs.config.DevTransforms || len(names) == 0 {
+		return ""
+	}
+
+	quoted := make([]string, len(names))
+	for index, name := range names {
+		quoted[index] = fmt.Sprintf("%q", name)
+	}
+
+	return fmt.Sprintf(transformsAssign, strings.Join(quoted, ","))
+}
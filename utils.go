@@ -1,37 +1,11 @@
 package assets
 
 import (
-	"bytes"
-	"compress/gzip"
-	"io"
 	"os"
 	"path/filepath"
 	"sort"
-	"strings"
 )
 
-// createCompressWriter creates a non-gzip compressed writer
-func createUnCompressWriter(w io.Writer) io.WriteCloser {
-	return &nopWriter{w}
-}
-
-// createCompressWriter creates a gzip compressed writer
-func createCompressWriter(w io.Writer) io.WriteCloser {
-	return gzip.NewWriter(&StringWriter{W: w})
-}
-
-// sanitize prepares a valid UTF-8 string as a raw string constant.
-func sanitize(b []byte) []byte {
-	// Replace ` with `+"`"+`
-	b = bytes.Replace(b, []byte("`"), []byte("`+\"`\"+`"), -1)
-
-	// Replace BOM with `+"\xEF\xBB\xBF"+`
-	// (A BOM is valid UTF-8 but not permitted in Go source files.
-	// I wouldn't bother handling this, but for some insane reason
-	// jquery.js has a BOM somewhere in the middle.)
-	return bytes.Replace(b, []byte("\xEF\xBB\xBF"), []byte("`+\"\\xEF\\xBB\\xBF\"+`"), -1)
-}
-
 // // readData takes a compressed gzip bytes and decompress it unless the virtual file wants no decompression
 // func readData(v *VFile, data []byte) ([]byte, error) {
 // 	if !v.Decompress {
@@ -107,15 +81,6 @@ func makeRelative(path string) string {
 	return path
 }
 
-func hasIn(paths []string, dt string) bool {
-	for _, so := range paths {
-		if strings.Contains(so, dt) || so == dt {
-			return true
-		}
-	}
-	return false
-}
-
 func hasExt(paths []string, dt string) bool {
 	for _, so := range paths {
 		if so == dt {
@@ -145,15 +110,3 @@ func getDirListings(dir string) ([]os.FileInfo, error) {
 
 	return files, nil
 }
-
-type nopWriter struct {
-	w io.Writer
-}
-
-func (n *nopWriter) Close() error {
-	return nil
-}
-
-func (n *nopWriter) Write(b []byte) (int, error) {
-	return n.w.Write(b)
-}
@@ -0,0 +1,298 @@
+package vfiles
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// archiveMagic ends every archive appended to a file, right after the length
+// of the archive it follows.
+const archiveMagic = "vfspack1"
+
+// archiveFooter is the size of the length and magic written after an appended
+// archive.
+const archiveFooter = 8 + len(archiveMagic)
+
+// ErrNoArchive is returned when no archive can be found for the running program
+var ErrNoArchive = errors.New("Archive not found next to or appended to the executable")
+
+// AppendArchive appends the archive to the file at exe, replacing any archive
+// appended to it before, so assets can be swapped without recompiling.
+func AppendArchive(exe string, archive io.Reader) error {
+	file, err := os.OpenFile(exe, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	size, offset, err := archiveBounds(file)
+	if err != nil && err != ErrNoArchive {
+		return err
+	}
+
+	if err == nil {
+		size = offset
+	}
+
+	if err := file.Truncate(size); err != nil {
+		return err
+	}
+
+	if _, err := file.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+
+	written, err := io.Copy(file, archive)
+	if err != nil {
+		return err
+	}
+
+	footer := make([]byte, archiveFooter)
+	binary.LittleEndian.PutUint64(footer, uint64(written))
+	copy(footer[8:], archiveMagic)
+
+	_, err = file.Write(footer)
+	return err
+}
+
+// archiveBounds returns the size of the file and the offset the archive
+// appended to it starts at, or ErrNoArchive if it carries none.
+func archiveBounds(file *os.File) (int64, int64, error) {
+	stat, err := file.Stat()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size := stat.Size()
+	if size < int64(archiveFooter) {
+		return size, 0, ErrNoArchive
+	}
+
+	footer := make([]byte, archiveFooter)
+	if _, err := file.ReadAt(footer, size-int64(archiveFooter)); err != nil {
+		return size, 0, err
+	}
+
+	if string(footer[8:]) != archiveMagic {
+		return size, 0, ErrNoArchive
+	}
+
+	length := int64(binary.LittleEndian.Uint64(footer))
+	offset := size - int64(archiveFooter) - length
+
+	if length < 0 || offset < 0 {
+		return size, 0, fmt.Errorf("Archive length %d does not fit %q", length, file.Name())
+	}
+
+	return size, offset, nil
+}
+
+// OpenArchive opens the archive appended to the file at the path, or the file
+// itself when nothing was appended to it. The file is kept open for reading
+// the archived files.
+func OpenArchive(file string) (*zip.Reader, error) {
+	fo, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+
+	size, offset, err := archiveBounds(fo)
+	switch err {
+	case nil:
+		size = size - int64(archiveFooter) - offset
+	case ErrNoArchive:
+		offset = 0
+	default:
+		fo.Close()
+		return nil, err
+	}
+
+	reader, err := zip.NewReader(io.NewSectionReader(fo, offset, size), size)
+	if err != nil {
+		fo.Close()
+		return nil, fmt.Errorf("---> vfiles.OpenArchive: %q holds no valid archive: %s", file, err)
+	}
+
+	return reader, nil
+}
+
+// ArchiveOptions sets how the files of a mounted archive are served, as the
+// generated files of the other modes do for theirs.
+type ArchiveOptions struct {
+	BaseDir      string // directory the sources of the files are found relative to, which they are read from when DiskEnv is set
+	NoDecompress bool   // returns the data of deflated files compressed with the flate codec as it is stored
+}
+
+// LoadArchive mounts the archive named sidecar next to the running executable
+// into the collector, falling back to the archive appended to the executable.
+func LoadArchive(c DirCollector, sidecar string, options ArchiveOptions) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var archive *zip.Reader
+
+	if sidecar != "" {
+		archive, err = OpenArchive(filepath.Join(filepath.Dir(exe), sidecar))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if archive == nil {
+		fo, err := os.Open(exe)
+		if err != nil {
+			return err
+		}
+
+		_, _, err = archiveBounds(fo)
+		fo.Close()
+
+		if err != nil {
+			return err
+		}
+
+		if archive, err = OpenArchive(exe); err != nil {
+			return err
+		}
+	}
+
+	return MountArchive(c, archive, options)
+}
+
+// MountArchive adds the directories and files of the archive into the
+// collector. The archive comment names the root directory every entry is
+// relative to, while the comment of each file holds its checksum followed by
+// the path of its source relative to options.BaseDir.
+func MountArchive(c DirCollector, archive *zip.Reader, options ArchiveOptions) error {
+	root := archive.Comment
+	if root == "" {
+		root = "."
+	}
+
+	// paths below a root of / are kept without a leading slash, like generated ones
+	full := func(name string) string {
+		if root == "/" && name != "." {
+			return name
+		}
+		return path.Join(root, name)
+	}
+
+	dirs := make(map[string]*VDir)
+
+	var mkdir func(name string) *VDir
+	mkdir = func(name string) *VDir {
+		if dir, ok := dirs[name]; ok {
+			return dir
+		}
+
+		dir := NewVDir(full(name), "", "", name == ".")
+		dir.Mod = time.Time{}
+		dir.FileMode = 0755
+		dirs[name] = dir
+
+		if name != "." {
+			parent := mkdir(path.Dir(name))
+			base := path.Base(name)
+
+			parent.AddDirectory(base, func() *VDir {
+				return dir
+			})
+		}
+
+		return dir
+	}
+
+	mkdir(".")
+
+	for _, entry := range archive.File {
+		name := strings.TrimSuffix(path.Clean("/"+entry.Name), "/")
+		name = strings.TrimPrefix(name, "/")
+
+		if name == "" {
+			continue
+		}
+
+		if entry.FileInfo().IsDir() {
+			dir := mkdir(name)
+			dir.Mod = entry.Modified
+			dir.FileMode = entry.Mode().Perm()
+			continue
+		}
+
+		entry := entry
+		checksum, source, _ := strings.Cut(entry.Comment, " ")
+
+		//deflated entries are handed out as stored, which is what the flate codec writes
+		raw := options.NoDecompress && entry.Method == zip.Deflate
+
+		//without a recorded source the file can not be found on disk
+		var base string
+		if source != "" {
+			base = options.BaseDir
+		}
+
+		file := NewVFile(base, full(name), filepath.FromSlash(source), int64(entry.UncompressedSize64), raw, !raw, func(v *VFile) ([]byte, error) {
+			if fromDisk(v) {
+				return readDisk(v)
+			}
+
+			var reader io.ReadCloser
+			var err error
+
+			if raw {
+				var stored io.Reader
+				stored, err = entry.OpenRaw()
+				reader = io.NopCloser(stored)
+			} else {
+				reader, err = entry.Open()
+			}
+
+			if err != nil {
+				return nil, err
+			}
+
+			defer reader.Close()
+
+			var buf bytes.Buffer
+			buf.Grow(int(entry.UncompressedSize64))
+
+			if _, err := io.Copy(&buf, reader); err != nil {
+				return nil, err
+			}
+
+			return buf.Bytes(), nil
+		})
+
+		if source != "" && path.Base(source) != path.Base(name) {
+			file.ShadowName = path.Base(source)
+		}
+
+		if raw {
+			file.Codec = "flate"
+		}
+
+		file.Checksum = checksum
+		file.Mod = entry.Modified
+		file.FileMode = entry.Mode().Perm()
+
+		mkdir(path.Dir(name)).AddFile(file)
+	}
+
+	for name, dir := range dirs {
+		c.Set(full(name), dir)
+	}
+
+	return nil
+}
@@ -0,0 +1,168 @@
+package vfiles
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Codec defines a compression format the data of virtual files can be stored in
+type Codec interface {
+	// Name returns the name virtual files record to find the codec again
+	Name() string
+
+	// Encoder returns a writer compressing everything written into w
+	Encoder(w io.Writer) (io.WriteCloser, error)
+
+	// Decoder returns a reader decompressing the contents of r
+	Decoder(r io.Reader) (io.ReadCloser, error)
+}
+
+// GzipCodec provides a Codec storing data in the gzip format
+type GzipCodec struct {
+	Level int // compression level, zero uses the default level
+}
+
+// Name returns the name of the codec
+func (GzipCodec) Name() string {
+	return "gzip"
+}
+
+// Encoder returns a gzip writer for w
+func (g GzipCodec) Encoder(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriterLevel(w, codecLevel(g.Level))
+}
+
+// Decoder returns a gzip reader for r
+func (GzipCodec) Decoder(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+// ZlibCodec provides a Codec storing data in the zlib format, which is what
+// http calls the deflate content encoding
+type ZlibCodec struct {
+	Level int // compression level, zero uses the default level
+}
+
+// Name returns the name of the codec
+func (ZlibCodec) Name() string {
+	return "zlib"
+}
+
+// Encoder returns a zlib writer for w
+func (z ZlibCodec) Encoder(w io.Writer) (io.WriteCloser, error) {
+	return zlib.NewWriterLevel(w, codecLevel(z.Level))
+}
+
+// Decoder returns a zlib reader for r
+func (ZlibCodec) Decoder(r io.Reader) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+// FlateCodec provides a Codec storing data as a raw deflate stream without
+// any headers, making it the smallest of the stdlib formats
+type FlateCodec struct {
+	Level int // compression level, zero uses the default level
+}
+
+// Name returns the name of the codec
+func (FlateCodec) Name() string {
+	return "flate"
+}
+
+// Encoder returns a flate writer for w
+func (f FlateCodec) Encoder(w io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(w, codecLevel(f.Level))
+}
+
+// Decoder returns a flate reader for r
+func (FlateCodec) Decoder(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+// codecLevel turns the zero level into the default compression level
+func codecLevel(level int) int {
+	if level == 0 {
+		return flate.DefaultCompression
+	}
+	return level
+}
+
+var codecs = struct {
+	sync.RWMutex
+	list map[string]Codec
+}{
+	list: map[string]Codec{
+		"gzip":  GzipCodec{},
+		"zlib":  ZlibCodec{},
+		"flate": FlateCodec{},
+	},
+}
+
+// RegisterCodec adds a codec virtual files can be decoded with, replacing any
+// codec already registered under the same name
+func RegisterCodec(c Codec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.list[c.Name()] = c
+}
+
+// LookupCodec returns the codec registered with the given name
+func LookupCodec(name string) (Codec, error) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+
+	codec, ok := codecs.list[name]
+	if !ok {
+		return nil, fmt.Errorf("Codec %q is not registered", name)
+	}
+
+	return codec, nil
+}
+
+// Encode returns the data compressed with the codec
+func Encode(c Codec, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+
+	writer, err := c.Encoder(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		writer.Close()
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Decode returns the data decompressed with the codec
+func Decode(c Codec, data []byte) ([]byte, error) {
+	reader, err := c.Decoder(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, reader)
+	clerr := reader.Close()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if clerr != nil {
+		return nil, clerr
+	}
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,40 @@
+package vfiles
+
+import "path"
+
+// AssetManifest maps the logical paths of fingerprinted files to the paths
+// they are published under, both rooted at /
+type AssetManifest map[string]string
+
+// Path returns the path the file at the logical path is published under, or
+// the logical path itself when the file is not fingerprinted
+func (m AssetManifest) Path(logical string) string {
+	logical = path.Join("/", logical)
+
+	if published, ok := m[logical]; ok {
+		return published
+	}
+
+	return logical
+}
+
+// Asset returns the url of the file at the logical path below the given base
+// url, which is empty for files served from the root
+func (m AssetManifest) Asset(base, logical string) string {
+	if base == "" {
+		return m.Path(logical)
+	}
+
+	return path.Join(base, m.Path(logical))
+}
+
+// FuncMap returns the template functions resolving logical paths, where
+// {{ asset "css/app.css" }} returns the url of the fingerprinted file below
+// the given base url
+func (m AssetManifest) FuncMap(base string) map[string]interface{} {
+	return map[string]interface{}{
+		"asset": func(logical string) string {
+			return m.Asset(base, logical)
+		},
+	}
+}
@@ -0,0 +1,547 @@
+package vfiles
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// TransformFunc rewrites the contents of the file at the given path
+type TransformFunc func(path string, data []byte) ([]byte, error)
+
+var transforms = struct {
+	sync.RWMutex
+	list map[string]TransformFunc
+}{
+	list: map[string]TransformFunc{
+		"minify-css":   MinifyCSS,
+		"minify-js":    MinifyJS,
+		"minify-html":  MinifyHTML,
+		"compact-json": CompactJSON,
+	},
+}
+
+// RegisterTransform adds a transform files read from disk can be rewritten
+// with, replacing any transform already registered under the same name
+func RegisterTransform(name string, fn TransformFunc) {
+	transforms.Lock()
+	defer transforms.Unlock()
+	transforms.list[name] = fn
+}
+
+// LookupTransform returns the transform registered with the given name
+func LookupTransform(name string) (TransformFunc, error) {
+	transforms.RLock()
+	defer transforms.RUnlock()
+
+	fn, ok := transforms.list[name]
+	if !ok {
+		return nil, fmt.Errorf("Transform %q is not registered", name)
+	}
+
+	return fn, nil
+}
+
+// ApplyTransforms returns the data rewritten by the named transforms in order
+func ApplyTransforms(path string, data []byte, names ...string) ([]byte, error) {
+	for _, name := range names {
+		fn, err := LookupTransform(name)
+		if err != nil {
+			return nil, err
+		}
+
+		if data, err = fn(path, data); err != nil {
+			return nil, fmt.Errorf("Transform %q failed for %s: %s", name, path, err)
+		}
+	}
+
+	return data, nil
+}
+
+// CompactJSON removes the insignificant whitespace of JSON documents
+func CompactJSON(path string, data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MinifyCSS removes the comments of stylesheets, except the ones starting
+// with /*!, and the whitespace not needed to tell tokens apart
+func MinifyCSS(path string, data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	var space bool
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+
+		switch {
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := commentEnd(data, i)
+			if i+2 < len(data) && data[i+2] == '!' {
+				writeSpaced(&out, &space, data[i:end], cssTight)
+			} else {
+				// a dropped comment still separates the tokens around it
+				space = true
+			}
+			i = end - 1
+		case c == '"' || c == '\'':
+			end := quoteEnd(data, i)
+			writeSpaced(&out, &space, data[i:end], cssTight)
+			i = end - 1
+		case isSpace(c):
+			space = true
+		case c == '}' && lastByte(&out) == ';':
+			out.Truncate(out.Len() - 1)
+			space = false
+			out.WriteByte(c)
+		default:
+			writeSpaced(&out, &space, data[i:i+1], cssTight)
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// cssTight returns true if whitespace between the two bytes can be dropped,
+// which includes the inside of parentheses but not the space before them, as
+// in "and (max-width:10px)"
+func cssTight(prev, next byte) bool {
+	return bytes.IndexByte([]byte("{};,>~:("), prev) >= 0 || bytes.IndexByte([]byte("{};,>~)"), next) >= 0
+}
+
+// MinifyJS removes the comments of scripts, except the ones starting with /*!,
+// along with indentation, blank lines and the whitespace not needed to tell
+// tokens apart. Line breaks are kept so automatic semicolon insertion works as
+// it did before.
+//
+// Whether a / starts a regular expression or a division is decided by what
+// comes before it: after ) it starts one only when the parentheses hold the
+// condition of if, while, for or with, after ] and } it is always a division.
+// When the parentheses before it are not balanced, the rest of its line is
+// kept as is.
+func MinifyJS(path string, data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	var space, newline bool
+
+	// conditions holds for each open parenthesis whether it follows if, while,
+	// for or with, while closed holds the same for the last one closed and
+	// matched tells if it had an opening one at all
+	var conditions []bool
+	var closed, matched bool
+
+	// previous returns what was written so far and its last byte, leaving out
+	// the pending whitespace
+	previous := func() ([]byte, byte) {
+		content := bytes.TrimRight(out.Bytes(), " \n")
+		if len(content) == 0 {
+			return content, 0
+		}
+		return content, content[len(content)-1]
+	}
+
+	// regexp tells whether a / starts a regular expression literal rather than
+	// a division, going by what was written before it
+	regexp := func() bool {
+		content, last := previous()
+		switch {
+		case last == 0:
+			return true
+		case last == ')':
+			return matched && closed
+		case last == ']' || last == '}':
+			return false
+		case !isWord(last):
+			return true
+		}
+
+		// properties named like keywords, as in a.in / 2, are operands
+		word := lastWord(content)
+		if len(content) > len(word) && content[len(content)-len(word)-1] == '.' {
+			return false
+		}
+
+		switch word {
+		case "return", "typeof", "instanceof", "in", "of", "new", "delete", "void", "throw", "case", "do", "else", "yield", "await":
+			return true
+		}
+
+		return false
+	}
+
+	// undecided tells whether a / follows parentheses without an opening one,
+	// which leaves no telling what it starts
+	undecided := func() bool {
+		_, last := previous()
+		return last == ')' && !matched
+	}
+
+	write := func(token []byte) {
+		if newline && out.Len() > 0 {
+			out.WriteByte('\n')
+		} else if space && out.Len() > 0 && !jsTight(lastByte(&out), token[0]) {
+			out.WriteByte(' ')
+		}
+
+		space, newline = false, false
+		out.Write(token)
+	}
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+
+		switch {
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			for i < len(data) && data[i] != '\n' {
+				i++
+			}
+			newline = true
+		case c == '/' && i+1 < len(data) && data[i+1] == '*':
+			end := commentEnd(data, i)
+			if i+2 < len(data) && data[i+2] == '!' {
+				write(data[i:end])
+			} else if bytes.IndexByte(data[i:end], '\n') >= 0 {
+				newline = true
+			} else {
+				space = true
+			}
+			i = end - 1
+		case c == '/' && undecided():
+			// neither a division nor a regular expression can be told apart, so
+			// the line is left alone
+			end := bytes.IndexByte(data[i:], '\n')
+			if end < 0 {
+				end = len(data)
+			} else {
+				end += i
+			}
+			write(data[i:end])
+			i = end - 1
+		case c == '/' && regexp():
+			end := regexpEnd(data, i)
+			write(data[i:end])
+			i = end - 1
+		case c == '"' || c == '\'':
+			end := quoteEnd(data, i)
+			write(data[i:end])
+			i = end - 1
+		case c == '`':
+			end := templateEnd(data, i)
+			write(data[i:end])
+			i = end - 1
+		case c == '\n':
+			newline = true
+		case isSpace(c):
+			space = true
+		case c == '(':
+			content, _ := previous()
+			switch lastWord(content) {
+			case "if", "while", "for", "with":
+				conditions = append(conditions, true)
+			default:
+				conditions = append(conditions, false)
+			}
+			write(data[i : i+1])
+		case c == ')':
+			matched = len(conditions) > 0
+			if matched {
+				closed, conditions = conditions[len(conditions)-1], conditions[:len(conditions)-1]
+			}
+			write(data[i : i+1])
+		default:
+			write(data[i : i+1])
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// lastWord returns the identifier or keyword the content ends with, if any
+func lastWord(content []byte) string {
+	start := len(content)
+	for start > 0 && isWord(content[start-1]) {
+		start--
+	}
+	return string(content[start:])
+}
+
+// jsTight returns true if whitespace between the two bytes can be dropped
+func jsTight(prev, next byte) bool {
+	switch {
+	case isWord(prev) && isWord(next):
+		return false
+	case prev == next && (prev == '+' || prev == '-' || prev == '/'):
+		return false
+	case prev >= '0' && prev <= '9' && next == '.':
+		return false
+	}
+	return true
+}
+
+// MinifyHTML removes the comments of documents, except conditional ones, and
+// collapses whitespace into single spaces. The contents of pre, textarea,
+// script and style elements and of {{ }} template actions are kept as is
+func MinifyHTML(path string, data []byte) ([]byte, error) {
+	var out bytes.Buffer
+	var space bool
+
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+
+		switch {
+		case bytes.HasPrefix(data[i:], []byte("<!--")):
+			end := bytes.Index(data[i+4:], []byte("-->"))
+			if end < 0 {
+				end = len(data)
+			} else {
+				end += i + 7
+			}
+
+			if bytes.HasPrefix(data[i:], []byte("<!--[if")) || bytes.HasPrefix(data[i:], []byte("<!--<![endif")) {
+				writeSpaced(&out, &space, data[i:end], htmlTight)
+			}
+			i = end - 1
+		case bytes.HasPrefix(data[i:], []byte("{{")):
+			end := bytes.Index(data[i+2:], []byte("}}"))
+			if end < 0 {
+				end = len(data)
+			} else {
+				end += i + 4
+			}
+
+			writeSpaced(&out, &space, data[i:end], htmlTight)
+			i = end - 1
+		case c == '<' && i+1 < len(data) && (isLetter(data[i+1]) || data[i+1] == '/' || data[i+1] == '!'):
+			end := tagEnd(data, i)
+			writeSpaced(&out, &space, collapseTag(data[i:end]), htmlTight)
+
+			if name := tagName(data[i:end]); rawElements[name] {
+				closing := closingTag(data, end, name)
+				out.Write(data[end:closing])
+				end = closing
+			}
+			i = end - 1
+		case isSpace(c):
+			space = true
+		default:
+			writeSpaced(&out, &space, data[i:i+1], htmlTight)
+		}
+	}
+
+	return out.Bytes(), nil
+}
+
+// htmlTight keeps a single space wherever documents hold whitespace, as it
+// can matter between inline elements
+func htmlTight(prev, next byte) bool {
+	return false
+}
+
+// rawElements lists the elements whose contents MinifyHTML keeps as is
+var rawElements = map[string]bool{"pre": true, "textarea": true, "script": true, "style": true}
+
+// collapseTag collapses the whitespace within a tag outside of its quoted
+// attribute values
+func collapseTag(tag []byte) []byte {
+	var out bytes.Buffer
+	var space bool
+
+	for i := 0; i < len(tag); i++ {
+		c := tag[i]
+
+		switch {
+		case c == '"' || c == '\'':
+			end := bytes.IndexByte(tag[i+1:], c)
+			if end < 0 {
+				end = len(tag)
+			} else {
+				end += i + 2
+			}
+
+			writeSpaced(&out, &space, tag[i:end], htmlTagTight)
+			i = end - 1
+		case isSpace(c):
+			space = true
+		default:
+			writeSpaced(&out, &space, tag[i:i+1], htmlTagTight)
+		}
+	}
+
+	return out.Bytes()
+}
+
+// htmlTagTight returns true if whitespace between the two bytes of a tag can
+// be dropped
+func htmlTagTight(prev, next byte) bool {
+	return prev == '=' || next == '=' || next == '>' || (next == '/' && prev == '"')
+}
+
+// tagEnd returns the index after the tag starting at i
+func tagEnd(data []byte, i int) int {
+	for j := i + 1; j < len(data); j++ {
+		switch data[j] {
+		case '"', '\'':
+			end := bytes.IndexByte(data[j+1:], data[j])
+			if end < 0 {
+				return len(data)
+			}
+			j += end + 1
+		case '>':
+			return j + 1
+		}
+	}
+	return len(data)
+}
+
+// tagName returns the lower cased name of an opening tag, or an empty string
+// for any other tag
+func tagName(tag []byte) string {
+	end := 1
+	for end < len(tag) && (isLetter(tag[end]) || (tag[end] >= '0' && tag[end] <= '9')) {
+		end++
+	}
+	return string(bytes.ToLower(tag[1:end]))
+}
+
+// closingTag returns the index of the closing tag of the named element found
+// after i, or the end of the data
+func closingTag(data []byte, i int, name string) int {
+	end := bytes.Index(bytes.ToLower(data[i:]), []byte("</"+name))
+	if end < 0 {
+		return len(data)
+	}
+	return i + end
+}
+
+// writeSpaced writes the token, preceded by a single space if whitespace was
+// seen before it and tight does not allow dropping it
+func writeSpaced(out *bytes.Buffer, space *bool, token []byte, tight func(prev, next byte) bool) {
+	if *space && out.Len() > 0 && !tight(lastByte(out), token[0]) {
+		out.WriteByte(' ')
+	}
+
+	*space = false
+	out.Write(token)
+}
+
+// lastByte returns the last byte written to the buffer, or zero
+func lastByte(out *bytes.Buffer) byte {
+	if out.Len() == 0 {
+		return 0
+	}
+	return out.Bytes()[out.Len()-1]
+}
+
+// commentEnd returns the index after the /* */ comment starting at i
+func commentEnd(data []byte, i int) int {
+	end := bytes.Index(data[i+2:], []byte("*/"))
+	if end < 0 {
+		return len(data)
+	}
+	return i + 2 + end + 2
+}
+
+// quoteEnd returns the index after the quoted string starting at i, which
+// also ends at an unescaped line break
+func quoteEnd(data []byte, i int) int {
+	for j := i + 1; j < len(data); j++ {
+		switch data[j] {
+		case '\\':
+			// a line continuation ending in \r\n is escaped as a whole
+			if j+2 < len(data) && data[j+1] == '\r' && data[j+2] == '\n' {
+				j++
+			}
+			j++
+		case data[i], '\n':
+			return j + 1
+		}
+	}
+	return len(data)
+}
+
+// templateEnd returns the index after the template literal starting at i,
+// skipping over the expressions it holds
+func templateEnd(data []byte, i int) int {
+	for j := i + 1; j < len(data); j++ {
+		switch {
+		case data[j] == '\\':
+			j++
+		case data[j] == '`':
+			return j + 1
+		case data[j] == '$' && j+1 < len(data) && data[j+1] == '{':
+			j = expressionEnd(data, j+2) - 1
+		}
+	}
+	return len(data)
+}
+
+// expressionEnd returns the index after the } closing the template literal
+// expression starting at i
+func expressionEnd(data []byte, i int) int {
+	depth := 0
+
+	for j := i; j < len(data); j++ {
+		switch data[j] {
+		case '"', '\'':
+			j = quoteEnd(data, j) - 1
+		case '`':
+			j = templateEnd(data, j) - 1
+		case '{':
+			depth++
+		case '}':
+			if depth == 0 {
+				return j + 1
+			}
+			depth--
+		}
+	}
+	return len(data)
+}
+
+// regexpEnd returns the index after the regular expression literal starting
+// at i, including its flags
+func regexpEnd(data []byte, i int) int {
+	var class bool
+
+	for j := i + 1; j < len(data); j++ {
+		switch data[j] {
+		case '\\':
+			j++
+		case '[':
+			class = true
+		case ']':
+			class = false
+		case '\n':
+			return j
+		case '/':
+			if class {
+				continue
+			}
+
+			j++
+			for j < len(data) && isWord(data[j]) {
+				j++
+			}
+			return j
+		}
+	}
+	return len(data)
+}
+
+// isSpace returns true for ascii whitespace
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '\v'
+}
+
+// isWord returns true for the bytes identifiers and numbers are made of,
+// which includes every byte of non-ascii characters
+func isWord(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// isLetter returns true for ascii letters
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
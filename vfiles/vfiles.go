@@ -2,14 +2,17 @@ package vfiles
 
 import (
 	"bytes"
-	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"html/template"
 	"io"
+	"io/fs"
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
@@ -158,6 +161,7 @@ type VDir struct {
 	Files     FileCollector
 	SubMutex  sync.RWMutex
 	Subs      DeferDirCollector
+	aliases   map[string]string
 	root      bool
 }
 
@@ -172,10 +176,11 @@ func NewVDir(moddedPath, realPath, abs string, root bool) *VDir {
 	}
 
 	return &VDir{
-		VFile: &vf,
-		Files: NewFileCollector(),
-		Subs:  NewDeferDirCollector(),
-		root:  root,
+		VFile:   &vf,
+		Files:   NewFileCollector(),
+		Subs:    NewDeferDirCollector(),
+		aliases: make(map[string]string),
+		root:    root,
 	}
 }
 
@@ -187,6 +192,11 @@ func (vd *VDir) IsDir() bool {
 	return true
 }
 
+// Mode returns the filemode recorded for the directory with os.ModeDir set
+func (vd *VDir) Mode() os.FileMode {
+	return os.ModeDir | vd.FileMode
+}
+
 // DeferVDir defines a function type that returns a VDir
 type DeferVDir func() *VDir
 
@@ -288,6 +298,10 @@ func (vd *VDir) GetFile(file string) (*VFile, error) {
 		var vfile *VFile
 
 		vd.FileMutex.RLock()
+		if target, ok := vd.aliases[basename]; ok && !vd.Files.Has(basename) {
+			basename = target
+		}
+
 		if vd.Files.Has(basename) {
 			vfile = vd.Files.Get(basename)
 		}
@@ -366,6 +380,14 @@ func (vd *VDir) AddFile(vf *VFile) {
 	vd.Files.Set(vf.Name(), vf)
 }
 
+// AddAlias makes the file added under the target name within the directory
+// resolvable by another name as well, without listing it twice
+func (vd *VDir) AddAlias(name, target string) {
+	vd.FileMutex.Lock()
+	defer vd.FileMutex.Unlock()
+	vd.aliases[name] = target
+}
+
 // Close does nothing
 func (vd *VDir) Close() error {
 	return nil
@@ -382,7 +404,13 @@ type VFile struct {
 	BaseDir       string
 	Dir           string
 	FileName      string
+	ShadowName    string // name of the file on disk when it is published under another one
 	Datasize      int64
+	Checksum      string
+	Codec         string
+	Transforms    []string // transforms applied to the contents read from disk
+	Live          bool     // the file is read from disk, which its modification time is taken from too
+	FileMode      os.FileMode
 	processedPack []byte
 	DataPack      DataPack
 	Mod           time.Time
@@ -410,6 +438,10 @@ func NewVFile(pwd, modded, real string, size int64, compressed, decompress bool,
 
 // RealPath returns the true path of the file/dir on the filesystem, this is usually the same with the Path() but if a path mutation occured this returns the original path
 func (v *VFile) RealPath() string {
+	if v.ShadowName != "" {
+		return filepath.Join(v.BaseDir, v.ShadowDir, v.ShadowName)
+	}
+
 	return filepath.Join(v.BaseDir, v.ShadowDir, v.FileName)
 }
 
@@ -461,9 +493,60 @@ func (v *VFile) Data() ([]byte, error) {
 	return pack, nil
 }
 
-// Mode returns 0 as the filemode
+// CodecName returns the name of the codec the data of the file is compressed
+// with, files compressed without naming one are gzipped.
+func (v *VFile) CodecName() string {
+	if v.Codec == "" {
+		return "gzip"
+	}
+	return v.Codec
+}
+
+// ContentEncoding returns the http Content-Encoding the data returned by Data
+// can be sent with as is, or an empty string when the data is not compressed
+// in a format http clients understand.
+func (v *VFile) ContentEncoding() string {
+	if !v.Compressed || v.Decompress {
+		return ""
+	}
+
+	switch v.CodecName() {
+	case "gzip":
+		return "gzip"
+	case "zlib":
+		return "deflate"
+	}
+
+	return ""
+}
+
+// Hash returns the hex encoded SHA-256 digest of the uncompressed contents of
+// the file. Generated production files carry the digest computed when they were
+// recorded, otherwise it is computed from the data on every call, so files read
+// from disk always report their current contents.
+func (v *VFile) Hash() (string, error) {
+	if v.Checksum != "" {
+		return v.Checksum, nil
+	}
+
+	data, err := v.Data()
+	if err != nil {
+		return "", err
+	}
+
+	if v.Compressed && !v.Decompress {
+		if data, err = readEData(v, data); err != nil {
+			return "", err
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Mode returns the filemode recorded for the file
 func (v *VFile) Mode() os.FileMode {
-	return 0
+	return v.FileMode
 }
 
 // Size returns the size of the data
@@ -474,8 +557,15 @@ func (v *VFile) Size() int64 {
 	return v.Datasize
 }
 
-// ModTime returns the modtime for the virtual file
+// ModTime returns the modtime for the virtual file, which is the one of the
+// file on disk when it is read from there
 func (v *VFile) ModTime() time.Time {
+	if v.Live || fromDisk(v) {
+		if info, err := os.Stat(v.RealPath()); err == nil {
+			return info.ModTime()
+		}
+	}
+
 	return v.Mod
 }
 
@@ -672,6 +762,19 @@ func (c DirCollector) Root() *VDir {
 	return vdir
 }
 
+// Rebase makes every directory and file of the collector read from disk
+// relative to the given directory, in place of the one its generated file was
+// built from. It has to be called before files are read.
+func (c DirCollector) Rebase(dir string) {
+	for _, vd := range c {
+		vd.BaseDir = dir
+
+		vd.EachFile(func(v *VFile, _ string, _ func()) {
+			v.BaseDir = dir
+		})
+	}
+}
+
 // Open meets the http.FileSystem interface requirements
 func (c DirCollector) Open(file string) (http.File, error) {
 	vf, err := c.GetFile(file)
@@ -856,27 +959,159 @@ func cleanPath(dir string) string {
 }
 
 func readEData(v *VFile, data []byte) ([]byte, error) {
-	// reader, err := gzip.NewReader(strings.NewReader(data))
-	reader, err := gzip.NewReader(bytes.NewBuffer(data))
+	codec, err := LookupCodec(v.CodecName())
 	if err != nil {
 		return nil, fmt.Errorf("---> VFile.readData.error: read file %q at %q, due to: %q\n", v.Name(), v.Path(), err)
 	}
 
-	var buf bytes.Buffer
-	_, err = io.Copy(&buf, reader)
-	clerr := reader.Close()
+	decoded, err := Decode(codec, data)
+	if err != nil {
+		return nil, fmt.Errorf("---> VFile.readData.error: read file %q at %q, due to %s reader error: %q\n", v.Name(), v.Path(), codec.Name(), err)
+	}
+
+	return decoded, nil
+}
+
+func readVData(v *VFile, data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// DiskEnv names the environment variable which, when set to any value, makes
+// ReadData and ReadEmbed read files from the disk location they were embedded
+// from, so a production build can be worked on without recording it again.
+const DiskEnv = "ASSETS_FROM_DISK"
+
+// fromDisk returns true if the file should be read from disk rather than from
+// the data embedded for it.
+func fromDisk(v *VFile) bool {
+	return v.BaseDir != "" && os.Getenv(DiskEnv) != ""
+}
+
+// readDisk reads a virtual file from disk, in the form its embedded data would
+// be returned.
+func readDisk(v *VFile) ([]byte, error) {
+	if v.Compressed && !v.Decompress {
+		return ReadCompressedFile(v)
+	}
 
+	return ReadFile(v)
+}
+
+// ReadData returns the data embedded for a virtual file, decompressing it
+// unless the file was stored compressed with decompression disabled. The file
+// is read from disk instead when DiskEnv is set.
+func ReadData(v *VFile, data []byte) ([]byte, error) {
+	if fromDisk(v) {
+		return readDisk(v)
+	}
+
+	if v.Compressed && v.Decompress {
+		return readEData(v, data)
+	}
+
+	return readVData(v, data)
+}
+
+// ReadEmbed returns the data embedded for a virtual file under the given name
+// within files, decompressing it like ReadData.
+func ReadEmbed(v *VFile, files fs.FS, name string) ([]byte, error) {
+	if fromDisk(v) {
+		return readDisk(v)
+	}
+
+	data, err := fs.ReadFile(files, name)
 	if err != nil {
-		return nil, fmt.Errorf("---> VFile.readData.error: read file %q at %q, due to gzip reader error: %q\n", v.Name(), v.Path(), err)
+		return nil, fmt.Errorf("---> VFile.readData.error: read file %q at %q, due to: %q\n", v.Name(), v.Path(), err)
 	}
 
-	if clerr != nil {
-		return nil, clerr
+	return ReadData(v, data)
+}
+
+// ReadFile returns the contents of the file on disk a virtual file points to,
+// rewritten by the transforms of the virtual file.
+func ReadFile(v *VFile) ([]byte, error) {
+	fo, err := os.Open(v.RealPath())
+	if err != nil {
+		return nil, fmt.Errorf("---> assets.readFile: Error reading file: %s at %s: %s\n", v.Name(), v.RealPath(), err)
+	}
+
+	defer fo.Close()
+
+	var buf bytes.Buffer
+
+	_, err = io.Copy(&buf, fo)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if len(v.Transforms) > 0 {
+		return ApplyTransforms(v.RealPath(), buf.Bytes(), v.Transforms...)
 	}
 
 	return buf.Bytes(), nil
 }
 
-func readVData(v *VFile, data []byte) ([]byte, error) {
-	return data, nil
+// ReadGzipFile returns the gzipped contents of the file on disk a virtual file
+// points to.
+func ReadGzipFile(v *VFile) ([]byte, error) {
+	return readCodecFile(v, GzipCodec{})
+}
+
+// ReadCompressedFile returns the contents of the file on disk a virtual file
+// points to, compressed with the codec of the virtual file.
+func ReadCompressedFile(v *VFile) ([]byte, error) {
+	codec, err := LookupCodec(v.CodecName())
+	if err != nil {
+		return nil, fmt.Errorf("---> assets.readFile: Error reading file: %s at %s: %s\n", v.Name(), v.RealPath(), err)
+	}
+
+	return readCodecFile(v, codec)
+}
+
+func readCodecFile(v *VFile, codec Codec) ([]byte, error) {
+	data, err := ReadFile(v)
+	if err != nil {
+		return nil, err
+	}
+
+	encoded, err := Encode(codec, data)
+	if err != nil {
+		return nil, fmt.Errorf("---> assets.readFile.%s: Error compressing file: %s at %s: %s\n", codec.Name(), v.Name(), v.RealPath(), err)
+	}
+
+	return encoded, nil
+}
+
+// CallerDir returns the directory of the source file calling it, generated
+// dev-mode files use it to read their files relative to their own location.
+func CallerDir() string {
+	_, file, _, ok := runtime.Caller(1)
+	if !ok {
+		return ""
+	}
+
+	return filepath.Dir(file)
+}
+
+// BaseDirEnv names the environment variable holding the root of the module
+// generated files are found in, for when it moved since they were built.
+const BaseDirEnv = "ASSETS_BASE_DIR"
+
+// ResolveBase returns the directory a generated file reads its files relative
+// to, given the directory it was built from and its own directory relative to
+// the root of its module. When BaseDirEnv is set the directory is found below
+// it, otherwise the build directory is used unless it was trimmed from the
+// binary, in which case it is found below the working directory.
+func ResolveBase(caller, rel string) string {
+	if root := os.Getenv(BaseDirEnv); root != "" {
+		return filepath.Join(root, filepath.FromSlash(rel))
+	}
+
+	if !filepath.IsAbs(caller) {
+		if pwd, err := os.Getwd(); err == nil {
+			return filepath.Join(pwd, filepath.FromSlash(rel))
+		}
+	}
+
+	return caller
 }
@@ -3,7 +3,10 @@ package vfiles
 import (
 	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/influx6/flux"
 )
@@ -102,6 +105,10 @@ func TestVirtualDir(t *testing.T) {
 
 	dir, _ := root.GetDir("/assets/")
 
+	if !dir.Mode().IsDir() {
+		flux.FatalFailed(t, "Expected assets dir mode to be a directory, got %s", dir.Mode())
+	}
+
 	_, err := dir.GetFile("shop.md")
 
 	if err != nil {
@@ -148,3 +155,233 @@ func readData(v *VFile, data []byte) ([]byte, error) {
 
 	return readEData(v, data)
 }
+
+func TestVirtualFileHash(t *testing.T) {
+	// sha256 of "#Vim\n"
+	const sum = "9073d0b3daa11749739cb15422b78a0b2ea15dd35a916c991a00fbce37522942"
+
+	vf := NewVFile("./", "assets/bucklock.txt", "buklock.txt", 30, true, false, func(v *VFile) ([]byte, error) {
+		return ReadData(v, []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\x52\x0e\xcb\xcc\xe5\x02\x04\x00\x00\xff\xff\xec\xfe\xa5\xd9\x05\x00\x00\x00"))
+	})
+
+	hash, err := vf.Hash()
+	if err != nil {
+		flux.FatalFailed(t, "Error occured computing hash: %s", err)
+	}
+
+	if hash != sum {
+		flux.FatalFailed(t, "Incorrect hash of uncompressed contents, expected %s got %s", sum, hash)
+	}
+
+	vf.Checksum = "recorded"
+
+	if hash, _ := vf.Hash(); hash != "recorded" {
+		flux.FatalFailed(t, "Expected recorded checksum to be used, got %s", hash)
+	}
+
+	flux.LogPassed(t, "Successfully hashed contents of virtual file")
+}
+
+func TestCodecVirtualFiles(t *testing.T) {
+	encodings := map[Codec]string{
+		GzipCodec{}:          "gzip",
+		ZlibCodec{Level: 9}:  "deflate",
+		FlateCodec{Level: 1}: "",
+	}
+
+	for codec, encoding := range encodings {
+		data, err := Encode(codec, []byte("#Vim\n"))
+		if err != nil {
+			flux.FatalFailed(t, "Unable to encode with %s: %s", codec.Name(), err)
+		}
+
+		vf := NewVFile("./", "assets/vim.md", "vim.md", 5, true, true, func(v *VFile) ([]byte, error) {
+			return ReadData(v, data)
+		})
+		vf.Codec = codec.Name()
+
+		if content, err := vf.Data(); err != nil {
+			flux.FatalFailed(t, "Error occured retrieving %s content: %s", codec.Name(), err)
+		} else if string(content) != "#Vim\n" {
+			flux.FatalFailed(t, "Error in %s content expected %q got %q", codec.Name(), "#Vim\n", content)
+		}
+
+		if vf.ContentEncoding() != "" {
+			flux.FatalFailed(t, "Expected no content encoding for decompressed %s data", codec.Name())
+		}
+
+		vf.Decompress = false
+
+		if vf.ContentEncoding() != encoding {
+			flux.FatalFailed(t, "Expected %s data to have content encoding %q got %q", codec.Name(), encoding, vf.ContentEncoding())
+		}
+	}
+
+	flux.LogPassed(t, "Successfully read contents of codec compressed virtual files")
+}
+
+func TestDiskEnvVirtualFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vfiles")
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create temporary dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "vim.md"), []byte("#Vim 8\n"), 0644); err != nil {
+		flux.FatalFailed(t, "Unable to write file: %s", err)
+	}
+
+	vf := NewVFile(dir, "assets/vim.md", "vim.md", 5, false, false, func(v *VFile) ([]byte, error) {
+		return ReadData(v, []byte("#Vim\n"))
+	})
+
+	if data, _ := vf.Data(); string(data) != "#Vim\n" {
+		flux.FatalFailed(t, "Expected embedded content without %s but got %q", DiskEnv, data)
+	}
+
+	os.Setenv(DiskEnv, "1")
+	defer os.Unsetenv(DiskEnv)
+
+	if data, err := vf.Data(); err != nil {
+		flux.FatalFailed(t, "Error occured retrieving content: %s", err)
+	} else if string(data) != "#Vim 8\n" {
+		flux.FatalFailed(t, "Expected content from disk with %s but got %q", DiskEnv, data)
+	}
+
+	flux.LogPassed(t, "Successfully read virtual file from disk when asked to")
+}
+
+func TestTransforms(t *testing.T) {
+	cases := []struct {
+		transform TransformFunc
+		in, out   string
+	}{
+		{MinifyCSS, "/* c */\na > b ,\tc {\n  color: red;\n  content: \"a  ;  b\";\n}\n/*! kept */\n", "a>b,c{color:red;content:\"a  ;  b\"}/*! kept */"},
+		{MinifyCSS, "a:hover .b :first-child { width: calc(1px + 2px) }", "a:hover .b :first-child{width:calc(1px + 2px)}"},
+		{MinifyCSS, "a { margin:1px/**/2px; /* c */ }", "a{margin:1px 2px}"},
+		{MinifyCSS, "a:not( .b ) { width: calc( 1px + 2px ) }", "a:not(.b){width:calc(1px + 2px)}"},
+		{MinifyCSS, "@media screen and ( max-width: 10px ) { a { color: red } }", "@media screen and (max-width:10px){a{color:red}}"},
+		{MinifyJS, "// c\nvar a = 1 + +b;  /* c */\n\n\tvar re = /[/]\\/ +/g, s = 'a  // b'\nreturn a / 2\n", "var a=1+ +b;\nvar re=/[/]\\/ +/g,s='a  // b'\nreturn a/2"},
+		{MinifyJS, "let t = `a  ${ b + `c  ${d}` }  e`\nif (x) return /a b/.test(y)", "let t=`a  ${ b + `c  ${d}` }  e`\nif(x)return/a b/.test(y)"},
+		{MinifyJS, "if (x) /a  b/.test(y)\nvar c = (a + b) / 2 / d\nwhile (f(x)) /x  y/g.exec(z)", "if(x)/a  b/.test(y)\nvar c=(a+b)/2/d\nwhile(f(x))/x  y/g.exec(z)"},
+		{MinifyJS, "a) /  b  c/.test(y)\nvar d = 1", "a)/  b  c/.test(y)\nvar d=1"},
+		{MinifyJS, "var s = \"a  \\\r\nb  \"\nvar t = 1", "var s=\"a  \\\r\nb  \"\nvar t=1"},
+		{MinifyJS, "var h = a.in / 2 / 3, k = b.return / c / d", "var h=a.in/2/3,k=b.return/c/d"},
+		{MinifyHTML, "<!-- c -->\n<div  class=\"a  b\" >\n  <p>Hi   {{ .Name  }}</p>\n  <pre>  a\n b </pre>\n</div>\n", "<div class=\"a  b\"> <p>Hi {{ .Name  }}</p> <pre>  a\n b </pre> </div>"},
+		{CompactJSON, "{ \"a\": [1, 2],\n \"b\": \"c  d\" }\n", "{\"a\":[1,2],\"b\":\"c  d\"}"},
+	}
+
+	for _, test := range cases {
+		out, err := test.transform("file", []byte(test.in))
+		if err != nil {
+			flux.FatalFailed(t, "Unable to transform %s: %s", test.in, err)
+		}
+
+		if string(out) != test.out {
+			flux.FatalFailed(t, "Expected %s to become %s but got %s", test.in, test.out, out)
+		}
+	}
+
+	if _, err := ApplyTransforms("file", nil, "unknown"); err == nil {
+		flux.FatalFailed(t, "Expected an unknown transform to fail")
+	}
+
+	flux.LogPassed(t, "Successfully minified files")
+}
+
+func TestAssetManifest(t *testing.T) {
+	dir := NewVDir("/css", "css", "", false)
+	dir.AddFile(NewVFile("", "/css/app.3f2a1b9c.css", "css/app.css", 0, false, false, nil))
+	dir.AddAlias("app.css", "app.3f2a1b9c.css")
+
+	for _, name := range []string{"app.css", "app.3f2a1b9c.css"} {
+		if vf, err := dir.GetFile(name); err != nil || vf.Name() != "app.3f2a1b9c.css" {
+			flux.FatalFailed(t, "Expected %s to resolve to the published file", name)
+		}
+	}
+
+	if files, _ := dir.Readdir(10); len(files) != 1 {
+		flux.FatalFailed(t, "Expected aliases to be left out of listings")
+	}
+
+	manifest := AssetManifest{"/css/app.css": "/css/app.3f2a1b9c.css"}
+	asset := manifest.FuncMap("/static")["asset"].(func(string) string)
+
+	if url := asset("css/app.css"); url != "/static/css/app.3f2a1b9c.css" {
+		flux.FatalFailed(t, "Expected the fingerprinted url but got %s", url)
+	}
+
+	if url := manifest.Asset("", "img/a.png"); url != "/img/a.png" {
+		flux.FatalFailed(t, "Expected files without fingerprint to keep their path but got %s", url)
+	}
+
+	flux.LogPassed(t, "Successfully resolved fingerprinted files")
+}
+
+func TestLiveModTime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vfiles")
+	if err != nil {
+		flux.FatalFailed(t, "Unable to create temporary dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if err := ioutil.WriteFile(filepath.Join(dir, "vim.md"), []byte("#Vim\n"), 0644); err != nil {
+		flux.FatalFailed(t, "Unable to write file: %s", err)
+	}
+
+	edited := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := os.Chtimes(filepath.Join(dir, "vim.md"), edited, edited); err != nil {
+		flux.FatalFailed(t, "Unable to touch file: %s", err)
+	}
+
+	vf := NewVFile(dir, "assets/vim.md", "vim.md", 5, false, false, nil)
+	vf.Mod = time.Unix(0, 0)
+
+	if !vf.ModTime().Equal(vf.Mod) {
+		flux.FatalFailed(t, "Expected the recorded modification time for embedded files")
+	}
+
+	vf.Live = true
+
+	if !vf.ModTime().Equal(edited) {
+		flux.FatalFailed(t, "Expected the modification time on disk but got %s", vf.ModTime())
+	}
+
+	flux.LogPassed(t, "Files read from disk report their modification time on disk")
+}
+
+func TestRebaseVirtualDir(t *testing.T) {
+	dirs := NewDirCollector()
+
+	dir := NewVDir("/assets", "assets", "/built/here", true)
+	dir.AddFile(NewVFile("/built/here", "/assets/vim.md", "assets/vim.md", 5, false, false, nil))
+	dirs.Set("/assets", dir)
+
+	dirs.Rebase("/moved")
+
+	vf, err := dir.GetFile("vim.md")
+	if err != nil {
+		flux.FatalFailed(t, "Unable to get file: %s", err)
+	}
+
+	if vf.RealPath() != filepath.Join("/moved", "assets", "vim.md") || dir.BaseDir != "/moved" {
+		flux.FatalFailed(t, "Expected the file to be read from the new base but got %s", vf.RealPath())
+	}
+
+	os.Setenv(BaseDirEnv, "/module")
+	defer os.Unsetenv(BaseDirEnv)
+
+	if base := ResolveBase("/built/here/web", "web"); base != filepath.Join("/module", "web") {
+		flux.FatalFailed(t, "Expected the base to be found below %s but got %s", BaseDirEnv, base)
+	}
+
+	os.Unsetenv(BaseDirEnv)
+
+	if base := ResolveBase("/built/here/web", "web"); base != "/built/here/web" {
+		flux.FatalFailed(t, "Expected the build directory without %s but got %s", BaseDirEnv, base)
+	}
+
+	flux.LogPassed(t, "Successfully rebased virtual directories")
+}
@@ -0,0 +1,104 @@
+package assets
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/go-fsnotify/fsnotify"
+)
+
+// DefaultWatchDelay is how long Watch waits for changes to settle before
+// recording, unless BindFSConfig.WatchDelay says otherwise.
+const DefaultWatchDelay = 200 * time.Millisecond
+
+// Watch watches InDir and records the generated files again whenever files
+// which would be embedded change, waiting for bursts of changes to settle
+// first. Errors while watching or recording are logged and watching goes on
+// until the context is done, whose error is returned.
+func (bfs *BindFS) Watch(ctx context.Context) error {
+	if bfs.config.InDir == "" {
+		return fmt.Errorf("---> BindFS: Watch needs an InDir")
+	}
+
+	events := make(chan fsnotify.Event, 64)
+
+	watcher, err := NewWatch(WatcherConfig{
+		Dir:           bfs.config.InDir,
+		Skip:          bfs.config.IgnorePatterns,
+		NoIgnoreFiles: bfs.config.NoIgnoreFiles,
+		Dirs:          true,
+	}, func(err error, ev *fsnotify.Event, _ *Watcher) {
+		if err != nil {
+			log.Printf("---> BindFS: Error watching %s -> %s", bfs.config.InDir, err)
+			return
+		}
+
+		select {
+		case events <- *ev:
+		case <-ctx.Done():
+		}
+	})
+
+	if err != nil {
+		return fmt.Errorf("---> BindFS: Unable to watch %s -> %s", bfs.config.InDir, err)
+	}
+
+	//the generated files are never watched, the watcher's own rules are used as
+	//they are only reloaded by it while the ones of the BindFS are by Record
+	watcher.Filter = func(path, _ string) bool {
+		return !within(bfs.endpointDir, path) && !watcher.rules.Ignored(path, false)
+	}
+
+	go watcher.Start()
+	defer watcher.Stop()
+
+	delay := bfs.config.WatchDelay
+	if delay <= 0 {
+		delay = DefaultWatchDelay
+	}
+
+	//every relevant change pushes the recording back until none came for the delay
+	var settled <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case ev := <-events:
+			if bfs.changed(ev) {
+				settled = time.After(delay)
+			}
+		case <-settled:
+			settled = nil
+
+			if err := bfs.Record(); err != nil {
+				log.Printf("---> BindFS: Unable to record %s -> %s", bfs.config.InDir, err)
+			}
+		}
+	}
+}
+
+// changed returns true if the event touches a path the listing of InDir
+// would hold, or held before it was removed.
+func (bfs *BindFS) changed(ev fsnotify.Event) bool {
+	if ev.Op == fsnotify.Chmod || within(bfs.endpointDir, ev.Name) {
+		return false
+	}
+
+	//the listing of InDir always comes first
+	rules := bfs.rules[0]
+
+	info, err := os.Lstat(ev.Name)
+	if err != nil {
+		return !rules.Ignored(ev.Name, false)
+	}
+
+	if rules.Ignored(ev.Name, info.IsDir()) {
+		return false
+	}
+
+	return bfs.config.ValidPath(ev.Name, info)
+}
@@ -3,6 +3,7 @@ package assets
 import (
 	"go/build"
 	"log"
+	"os"
 	"path/filepath"
 	"strings"
 	"sync"
@@ -13,12 +14,14 @@ import (
 
 // WatcherConfig to be used with watcher
 type WatcherConfig struct {
-	Dir      string
-	Ext      []string
-	Skip     []string
-	MaxRetry int
-	ExtraPkg []string
-	Filter   func(addable, under string) bool
+	Dir           string
+	Ext           []string
+	Skip          []string // gitignore style patterns of paths within Dir to leave out
+	NoIgnoreFiles bool     // stops the .gitignore and .assetsignore files within Dir from being honoured
+	Dirs          bool     // also watches the directories holding the files, so files added to them are noticed
+	MaxRetry      int
+	ExtraPkg      []string
+	Filter        func(addable, under string) bool
 }
 
 // WatchMux defines a function type for watcher change notifications
@@ -29,9 +32,11 @@ type Watcher struct {
 	*WatcherConfig
 
 	assets AssetMap
+	rules  *IgnoreRules
 	ro     sync.Mutex
 	up     bool
 	stop   bool
+	done   chan struct{}
 	fx     WatchMux
 
 	// watcher *fsnotify.Watcher
@@ -40,15 +45,27 @@ type Watcher struct {
 // NewWatch returns a new watcher set over a directory for a specific extension if the ext is not a "" empty string and if not empty skips specific paths
 func NewWatch(c WatcherConfig, fx WatchMux) (*Watcher, error) {
 
-	tree, err := AssetTree(c.Dir, c.Ext, c.Skip)
+	rules, err := ignoreRules(c.Dir, c.Skip, c.NoIgnoreFiles)
 
 	if err != nil {
 		return nil, err
 	}
 
+	if _, err := os.Stat(c.Dir); err != nil {
+		return nil, err
+	}
+
+	tree := make(AssetMap)
+
+	if err := reloadAssetMap(tree, c.Dir, c.Ext, rules); err != nil {
+		return nil, err
+	}
+
 	ws := &Watcher{
 		WatcherConfig: &c,
 		assets:        tree,
+		rules:         rules,
+		done:          make(chan struct{}),
 		fx:            fx,
 	}
 
@@ -61,11 +78,16 @@ func NewWatch(c WatcherConfig, fx WatchMux) (*Watcher, error) {
 	return ws, nil
 }
 
-// Stop stops the watcher process
+// Stop stops the watcher process, making Start return. A stopped watcher can
+// not be started again.
 func (w *Watcher) Stop() {
 	w.ro.Lock()
 	defer w.ro.Unlock()
-	w.stop = true
+
+	if !w.stop {
+		w.stop = true
+		close(w.done)
+	}
 }
 
 func (w *Watcher) loadPkg(pkgname string) {
@@ -93,24 +115,24 @@ func (w *Watcher) loadPkg(pkgname string) {
 	}
 }
 
-// Start begins the watcher process
+// Start begins the watcher process, blocking until Stop is called
 func (w *Watcher) Start() {
 
 	//creation of fsnotify.Watcher retry count
 	var retry = 0
 
-	w.ro.Lock()
-	if w.stop {
-		w.stop = false
-	}
-	w.ro.Unlock()
-
 	// fmt.Printf("Loading asset tree of length: %d", len(w.assets))
 
 	for {
+		select {
+		case <-w.done:
+			return
+		default:
+		}
 
 		if w.up {
-			ReloadAssetMap(w.assets, w.Dir, w.Ext, w.Skip)
+			w.rules.Reload()
+			reloadAssetMap(w.assets, w.Dir, w.Ext, w.rules)
 			// if len(w.ExtraPkg) > 0 {
 			// 	for _, e := range w.ExtraPkg {
 			// 		w.loadPkg(e)
@@ -131,23 +153,37 @@ func (w *Watcher) Start() {
 			continue
 		}
 
+		// directories holding watched files, along with the root itself
+		dirs := map[string]bool{filepath.Clean(w.Dir): true}
+
 		for n, path := range w.assets {
 			path = EnsureSlash(path)
 
 			// if we have a filter function, use it to give more control as what gets added else use normal strategy
+			var addable bool
 			if w.Filter != nil {
-				if w.Filter(path, w.Dir) {
-					if err := wo.Add(path); err != nil {
-						log.Printf("Error adding file to watchlist: %s -> error: %s", path, err)
-						delete(w.assets, n)
-					}
-				}
+				addable = w.Filter(path, w.Dir)
 			} else {
-				if !strings.Contains(path, "bin") {
-					if err := wo.Add(path); err != nil {
-						log.Printf("Error adding file to watchlist: %s -> error: %s", path, err)
-						delete(w.assets, n)
-					}
+				addable = !w.rules.Ignored(path, false)
+			}
+
+			if !addable {
+				continue
+			}
+
+			if err := wo.Add(path); err != nil {
+				log.Printf("Error adding file to watchlist: %s -> error: %s", path, err)
+				delete(w.assets, n)
+				continue
+			}
+
+			dirs[filepath.Dir(path)] = true
+		}
+
+		if w.Dirs {
+			for dir := range dirs {
+				if err := wo.Add(dir); err != nil {
+					log.Printf("Error adding directory to watchlist: %s -> error: %s", dir, err)
 				}
 			}
 		}
@@ -157,6 +193,9 @@ func (w *Watcher) Start() {
 			w.fx(nil, &ev, w)
 		case erx := <-wo.Errors:
 			w.fx(erx, nil, w)
+		case <-w.done:
+			wo.Close()
+			return
 		}
 
 		wo.Close()